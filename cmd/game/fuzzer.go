@@ -2,22 +2,20 @@ package main
 
 import (
 	"fmt"
-	"jds/game"
+	"jds/game/world/fuzz"
 	"math/rand"
 	"runtime/debug"
 	"time"
 )
 
-// Returns random on-screen location
-func (te *TileEngine) RandomLocation(scale int) game.Location {
-	return te.ScreenToWorld(rand.Intn(WIDTH/scale)*scale, rand.Intn(HEIGHT/scale)*scale)
-}
-
+// Runs random operations from package fuzz against te.w, through the
+// journaled tools, until one panics or stopAt operations have run
 func (te *TileEngine) Fuzz(stopAt int) (err interface{}) {
 	fmt.Println("fuzzer running...")
 	ops := 0
 	lastOps := 0
 	lastStatus := time.Now()
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 	for ops != stopAt && !exit {
 		if time.Since(lastStatus) > 10*time.Second {
 			fmt.Printf("%.2f fuzz op/sec %d adds %d deletes %s\n",
@@ -41,25 +39,17 @@ func (te *TileEngine) Fuzz(stopAt int) (err interface{}) {
 			//if ops%1000 == 0 {
 			//	te.w.FsckWallTree()
 			//}
-			l1 := te.RandomLocation(90)
-			l2 := l1.JustOffset(rand.Intn(5)*30, rand.Intn(5)*30)
+			op := fuzz.RandomOp(rng)
 			var t Tool
-			switch rand.Intn(5) {
-			//case 0:
-			// Draw two points
-			//t = NewPointTool(te.w)
-			//case 1:
-			//Draw line
-			//t = NewLineTool(te.w)
-			case 2:
-				// Draw box
-				t = NewBoxTool(te.w)
-			default:
-				// Delete
-				t = NewDeleteTool(te.w)
-			case 0, 4:
-				t = NewPlaceDoorTool(te.w)
+			switch op.Type {
+			case fuzz.OP_BOX:
+				t = te.journal.Tool(te.w, toolIndex("Box"))
+			case fuzz.OP_DELETE:
+				t = te.journal.Tool(te.w, toolIndex("Delete"))
+			case fuzz.OP_PLACEDOOR:
+				t = te.journal.Tool(te.w, toolIndex("PlaceDoor"))
 			}
+			l1, l2 := op.Locations(te.tl)
 			te.background.UpdateBulk(t.Click(l1))
 			te.background.UpdateBulk(t.Click(l2))
 		}()
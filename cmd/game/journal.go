@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"jds/game"
+	"jds/game/world"
+	"jds/game/world/generate"
+	"math/rand"
+	"os"
+)
+
+// Journal operations
+const (
+	OP_TOOL = iota
+	OP_CLICK
+	OP_RIGHTCLICK
+)
+
+// Describes the World a Journal was recorded against. The World is a
+// generate.NewGridWorld, so it can be rebuilt from its dimensions and seed.
+type JournalHeader struct {
+	Width, Height int
+	Seed          int64
+}
+
+// One tool action. Entries are recorded before the action is performed, so
+// the action that crashed the game is the last entry of its journal.
+type JournalEntry struct {
+	// Value of World.Now when the action was performed
+	Tick game.Tick
+	Op   int
+	// Index into toolset, for OP_TOOL
+	Tool int
+	// Location clicked, for OP_CLICK and OP_RIGHTCLICK
+	L game.Location
+}
+
+// A Journal records the tool actions performed on a World, so they can be
+// replayed with Replay. A nil *Journal records nothing.
+//
+// Entries are buffered, and only written to the file by Flush and Close. The
+// game flushes after every frame, and when an action panics.
+type Journal struct {
+	f   *os.File
+	buf *bufio.Writer
+	enc *gob.Encoder
+}
+
+// Creates a journal file at path for World w, which must have been created
+// with generate.NewGridWorld(width, height, w.Seed())
+func NewJournal(path string, w *world.World, width, height int) (*Journal, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{
+		f:   f,
+		buf: bufio.NewWriter(f),
+	}
+	j.enc = gob.NewEncoder(j.buf)
+	err = j.enc.Encode(JournalHeader{
+		Width:  width,
+		Height: height,
+		Seed:   w.Seed(),
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *Journal) record(e JournalEntry) {
+	if j == nil {
+		return
+	}
+	if err := j.enc.Encode(e); err != nil {
+		panic(err)
+	}
+}
+
+// Writes the buffered entries to the journal file
+func (j *Journal) Flush() error {
+	if j == nil {
+		return nil
+	}
+	return j.buf.Flush()
+}
+
+// Creates toolset[index] for w, and records the tool switch. Clicks on the
+// returned Tool are recorded too.
+func (j *Journal) Tool(w *world.World, index int) Tool {
+	j.record(JournalEntry{
+		Tick: w.Now(),
+		Op:   OP_TOOL,
+		Tool: index,
+	})
+	t := toolset[index].Create(w)
+	if j == nil {
+		return t
+	}
+	return &journaledTool{
+		Tool: t,
+		j:    j,
+		w:    w,
+	}
+}
+
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	if err := j.Flush(); err != nil {
+		j.f.Close()
+		return err
+	}
+	return j.f.Close()
+}
+
+type journaledTool struct {
+	Tool
+	j *Journal
+	w *world.World
+}
+
+func (t *journaledTool) Click(l game.Location) game.ModMap {
+	t.j.record(JournalEntry{
+		Tick: t.w.Now(),
+		Op:   OP_CLICK,
+		L:    l,
+	})
+	return t.Tool.Click(l)
+}
+
+func (t *journaledTool) RightClick(l game.Location) game.ModMap {
+	t.j.record(JournalEntry{
+		Tick: t.w.Now(),
+		Op:   OP_RIGHTCLICK,
+		L:    l,
+	})
+	return t.Tool.RightClick(l)
+}
+
+// Returned by Replay when an action panics
+type ReplayError struct {
+	// Index of the entry that panicked
+	Op   int
+	Tick game.Tick
+	Err  interface{}
+	// world.LastOp when the panic was recovered
+	LastOp interface{}
+}
+
+func (e ReplayError) Error() string {
+	return fmt.Sprintf("op %d at tick %d: %v (last op %v)", e.Op, e.Tick, e.Err, e.LastOp)
+}
+
+// Replays the journal at path against a new headless World. Replay stops
+// before entry stopAt (if stopAt >= 0), at the end of the journal, or at the
+// first action that panics, which is returned as a ReplayError. If snapshot
+// is not empty, the World is saved there when Replay stops.
+func Replay(path string, stopAt int, snapshot string) (err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := gob.NewDecoder(f)
+	var h JournalHeader
+	if err = dec.Decode(&h); err != nil {
+		return err
+	}
+	w := generate.NewGridWorld(h.Width, h.Height, h.Seed)
+	toolRand = rand.New(rand.NewSource(w.Seed()))
+	defer func() {
+		if snapshot == "" {
+			return
+		}
+		if serr := saveSnapshot(w, snapshot); serr != nil && err == nil {
+			err = serr
+		}
+	}()
+	var tool Tool
+	for i := 0; i != stopAt; i++ {
+		var e JournalEntry
+		if err = dec.Decode(&e); err == io.EOF {
+			fmt.Println("replayed", i, "operations")
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for w.Now() < e.Tick {
+			w.Think()
+		}
+		if err = replayEntry(w, &tool, e); err != nil {
+			rerr := err.(ReplayError)
+			rerr.Op = i
+			return rerr
+		}
+	}
+	fmt.Println("stopped before operation", stopAt, "at tick", w.Now())
+	return nil
+}
+
+// Performs the action e on w, with *tool the current tool
+func replayEntry(w *world.World, tool *Tool, e JournalEntry) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = ReplayError{
+				Tick:   e.Tick,
+				Err:    r,
+				LastOp: world.LastOp,
+			}
+		}
+	}()
+	switch e.Op {
+	case OP_TOOL:
+		*tool = toolset[e.Tool].Create(w)
+	case OP_CLICK:
+		(*tool).Click(e.L)
+	case OP_RIGHTCLICK:
+		(*tool).RightClick(e.L)
+	default:
+		panic(fmt.Sprintf("unknown journal op %d", e.Op))
+	}
+	return nil
+}
+
+func saveSnapshot(w *world.World, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = w.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"jds/game"
 	"jds/game/layer"
@@ -98,6 +99,10 @@ type TileEngine struct {
 	Overlay      *layer.Layer
 	// Layers to be drawn
 	layers []*RenderLayer
+	// Tool actions are recorded here
+	journal *Journal
+	// Zoned rooms last reported unreachable, see warnUnreachable
+	unreachable map[world.RoomId]bool
 }
 
 func NewTileEngine(tileset string, W *world.World, w, h uint) (te *TileEngine, err error) {
@@ -152,13 +157,10 @@ func (te *TileEngine) Render() {
 		}
 		bi = bi.RightBlock()
 	}
-	// Render entities
-	for _, e := range te.w.Entities {
-		l := e.Location()
-		x, y := te.WorldToScreen(l)
-		if x < 0 || y < 0 || x >= WIDTH || y >= HEIGHT {
-			continue
-		}
+	// Render visible entities
+	for _, eid := range te.w.EntitiesInBox(te.tl, te.ScreenToWorld(WIDTH-1, HEIGHT-1)) {
+		e := te.w.Entities[eid]
+		x, y := te.WorldToScreen(e.Location())
 		ec := toSDLColor(e.Color())
 		te.T.Draw(te.R, 30, x, y, &ec, te.Scale)
 	}
@@ -247,6 +249,19 @@ type renderBackground struct {
 	te *TileEngine
 }
 
+// Returns the color of the tiles of d, nil for open doors
+func doorColor(d *world.Door) *sdl.Color {
+	switch d.State {
+	case world.DOOR_CLOSED:
+		return &sdl.Color{R: 255, G: 255, B: 0, A: 255}
+	case world.DOOR_LOCKED:
+		return &sdl.Color{R: 255, G: 0, B: 0, A: 255}
+	case world.DOOR_ONEWAY:
+		return &sdl.Color{R: 0, G: 128, B: 255, A: 255}
+	}
+	return nil
+}
+
 func IntToColor(r int) *sdl.Color {
 	return &sdl.Color{
 		R: uint8(16 * (r & 0x3)),
@@ -265,12 +280,13 @@ func (r *renderBackground) RenderBlock(bid game.BlockId) {
 	for l := range bid.Iterate() {
 		switch r.te.w.Walls.Get(l) {
 		case 1:
-			if r.te.w.DoorIds.Get(l) != 0 {
+			color = nil
+			if did := r.te.w.DoorIds.Get(l); did != 0 {
 				tile = 41
+				color = doorColor(r.te.w.Doors[world.DoorId(did)])
 			} else {
 				tile = 40
 			}
-			color = nil
 		default:
 			if rid := r.te.w.RoomIds.Get(l); rid != 0 {
 				tile = 224
@@ -443,13 +459,15 @@ func (te *TileEngine) Interactive() (err interface{}) {
 	defer func() {
 		err = recover()
 		if err != nil {
+			// The action that panicked is the journal's last entry
+			te.journal.Flush()
 			fmt.Println(err)
 			debug.PrintStack()
 		}
 	}()
 	var last game.Location
 	var toolMode int
-	tool := toolset[toolMode].Create(te.w)
+	tool := te.journal.Tool(te.w, toolMode)
 	lastStats := time.Now()
 	lastStatTick := te.w.Now()
 	te.w.Think()
@@ -458,13 +476,13 @@ func (te *TileEngine) Interactive() (err interface{}) {
 			switch event := event.(type) {
 			case *sdl.MouseButtonEvent:
 				if event.Button == 1 && event.State == 1 {
-					//logEnc.Encode(reflect.TypeOf(event).String())
-					//logEnc.Encode(*event)
 					l := te.ScreenToWorld(int(event.X), int(event.Y))
 					te.background.UpdateBulk(tool.Click(l))
+					te.warnUnreachable()
 				} else if event.Button == 3 && event.State == 1 {
 					l := te.ScreenToWorld(int(event.X), int(event.Y))
 					te.background.UpdateBulk(tool.RightClick(l))
+					te.warnUnreachable()
 				}
 			case *sdl.MouseMotionEvent:
 				l := te.ScreenToWorld(int(event.X), int(event.Y))
@@ -480,11 +498,9 @@ func (te *TileEngine) Interactive() (err interface{}) {
 					te.overlay.UpdateAll()
 				}
 			case *sdl.KeyDownEvent:
-				//logEnc.Encode(reflect.TypeOf(event).String())
-				//logEnc.Encode(*event)
 				if event.Keysym.Sym == sdl.K_SPACE {
 					toolMode = (toolMode + 1) % len(toolset)
-					tool = toolset[toolMode].Create(te.w)
+					tool = te.journal.Tool(te.w, toolMode)
 					fmt.Println("tool mode", toolMode, toolset[toolMode].Name)
 					te.Overlay.Discard()
 					te.overlay.UpdateAll()
@@ -506,14 +522,18 @@ func (te *TileEngine) Interactive() (err interface{}) {
 			te.w.Think()
 			mult++
 		}
+		te.journal.Flush()
 		if time.Since(lastStats) > 1*time.Second {
 			ticks := te.w.Now() - lastStatTick
 			fmt.Println("Avg workers per tick:", float32(te.w.ThinkStats.Workers)/float32(ticks))
 			fmt.Println("Avg Actions per worker:", float32(te.w.ThinkStats.Actions)/float32(te.w.ThinkStats.Workers))
 			fmt.Println("Avg time per Think:", te.w.ThinkStats.Elapsed/time.Duration(ticks))
+			fmt.Println("Avg jobs per tick:", float32(te.w.ThinkStats.Jobs)/float32(ticks))
+			fmt.Println("Jobs queued:", te.w.ThinkStats.JobQueue)
 			fmt.Println("Avg Actions per second:", float64(te.w.ThinkStats.Actions)/te.w.ThinkStats.Elapsed.Seconds())
 			te.w.ThinkStats.Actions = 0
 			te.w.ThinkStats.Workers = 0
+			te.w.ThinkStats.Jobs = 0
 			te.w.ThinkStats.Elapsed = 0
 			lastStatTick = te.w.Now()
 			lastStats = time.Now()
@@ -544,21 +564,60 @@ errorDisplayLoop:
 	}
 }
 
-func FuzzAndDebug() {
-	//logFile, err := os.OpenFile(time.Now().Format("2006.01.02.15.04.05.log"), os.O_CREATE|os.O_RDWR, 0644)
-	//if err != nil {
-	//	panic("could not open log file")
-	//}
-	//logGzip := gzip.NewWriter(logFile)
-	//logEnc := gob.NewEncoder(logGzip)
-	//defer logGzip.Close()
-	//w := world.NewWorld(0)
-	w := generate.NewGridWorld(50, 50) //world.NewWorld(0) //world.STRICT_FSCK_EVERY_OP)
+// Prints a warning for each zoned room that has become unreachable from
+// outside
+func (te *TileEngine) warnUnreachable() {
+	g := te.w.RoomGraph()
+	unreachable := make(map[world.RoomId]bool)
+	for c := world.SHOP_NONE; c < world.NUM_SHOP_CATEGORIES; c++ {
+		for _, rid := range te.w.ZonedRooms(c) {
+			if g.ReachableFromOutside(rid) {
+				continue
+			}
+			unreachable[rid] = true
+			if !te.unreachable[rid] {
+				fmt.Printf("Warning: %v room %d is unreachable\n", c, rid)
+			}
+		}
+	}
+	te.unreachable = unreachable
+}
+
+// Shows the layer of err, if it is a LayerError or FsckErrors, until a key
+// is pressed
+func (te *TileEngine) ShowError(err interface{}) {
+	switch err := err.(type) {
+	case world.LayerError:
+		te.ShowLayerError(err)
+	case world.FsckErrors:
+		for _, e := range err {
+			te.ShowLayerError(world.LayerError{
+				Message: e.Error(),
+				Layer:   e.Highlight(),
+			})
+		}
+	}
+}
+
+// Runs the fuzzer first if fuzz is true, then the interactive tools. Tool
+// actions are recorded to a Journal at journalPath, unless it is empty or
+// the fuzzer runs.
+func FuzzAndDebug(fuzz bool, journalPath string) {
+	w := generate.NewGridWorld(50, 50, time.Now().UnixNano()) //world.NewWorld(0) //world.STRICT_FSCK_EVERY_OP)
 	te, err := NewTileEngine("tileset.png", w, WIDTH, HEIGHT)
 	rand.Seed(int64(time.Now().Nanosecond()))
 	if err != nil {
 		panic(err)
 	}
+	toolRand = rand.New(rand.NewSource(w.Seed()))
+	if journalPath != "" && !fuzz {
+		te.journal, err = NewJournal(journalPath, w, 50, 50)
+		if err != nil {
+			panic(err)
+		}
+		defer te.journal.Close()
+		fmt.Println("recording journal to", journalPath)
+	}
 	/*lastLen := 0
 	for len(w.Rooms) < 200 {
 		a := te.RandomLocation(50)
@@ -577,14 +636,12 @@ func FuzzAndDebug() {
 	}
 	w.Drawbox(te.ScreenToWorld(0, 0), te.ScreenToWorld(WIDTH, HEIGHT))*/
 	for !exit {
-		if len(os.Args) > 1 {
+		if fuzz {
 			fuzzError := te.Fuzz(-1)
 			if fuzzError != nil {
 				fmt.Println(fuzzError)
 				fmt.Println("last op", world.LastOp)
-				if fuzzError, ok := fuzzError.(world.LayerError); ok {
-					te.ShowLayerError(fuzzError)
-				}
+				te.ShowError(fuzzError)
 				te.background.UpdateAll()
 			}
 			f, err := os.Create("mem.pprof")
@@ -598,19 +655,30 @@ func FuzzAndDebug() {
 		if err != nil {
 			fmt.Println(err)
 			fmt.Println("last op", world.LastOp)
-			if err, ok := err.(world.LayerError); ok {
-				te.ShowLayerError(err)
-			}
+			te.ShowError(err)
 			te.background.UpdateAll()
 		}
 	}
 }
 
 func main() {
+	fuzz := flag.Bool("fuzz", false, "run the fuzzer before the interactive tools")
+	journal := flag.String("journal", "", "record tool actions to this file, unless -fuzz is set")
+	replay := flag.String("replay", "", "replay this journal without SDL, instead of running interactively")
+	stop := flag.Int("stop", -1, "with -replay, stop before this operation")
+	snapshot := flag.String("snapshot", "", "with -replay, save the World to this file when the replay stops")
+	flag.Parse()
+	if *replay != "" {
+		if err := Replay(*replay, *stop, *snapshot); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
 	handleSigint()
 	startProfile()
 	defer pprof.StopCPUProfile()
-	FuzzAndDebug()
+	FuzzAndDebug(*fuzz, *journal)
 	metricNames := make([]string, 0, len(runstat.Metrics))
 	for k := range runstat.Metrics {
 		metricNames = append(metricNames, k)
@@ -39,6 +39,10 @@ var colorWhite = game.Color{
 	A: 255,
 }
 
+// Source of randomness for the tools. Seeded from the World's seed, so that
+// replaying a Journal spawns the same entities.
+var toolRand = rand.New(rand.NewSource(1))
+
 type Tool interface {
 	Preview(l game.Location) (<-chan game.Location, game.Color)
 	Click(l game.Location) game.ModMap
@@ -47,6 +51,16 @@ type Tool interface {
 
 type ToolCreator func(w *world.World) Tool
 
+// Returns the index of the tool called name in toolset
+func toolIndex(name string) int {
+	for i := range toolset {
+		if toolset[i].Name == name {
+			return i
+		}
+	}
+	panic("no tool " + name)
+}
+
 var toolset = []struct {
 	Name   string
 	Create ToolCreator
@@ -225,8 +239,20 @@ func (t PlaceDoorTool) Click(l game.Location) (m game.ModMap) {
 	return
 }
 
+// Cycles the state of the door at l
 func (t PlaceDoorTool) RightClick(l game.Location) (m game.ModMap) {
-	return nil
+	d := t.w.Doors[world.DoorId(t.w.DoorIds.Get(l))]
+	if d == nil {
+		return nil
+	}
+	t.w.SetDoorState(d.Id, (d.State+1)%world.NUM_DOOR_STATES)
+	m = game.NewModMap()
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			m.AddLocation(d.L.JustOffset(x, y))
+		}
+	}
+	return
 }
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -383,7 +409,12 @@ func (t *RouteWalkerTool) Preview(l game.Location) (c <-chan game.Location, colo
 
 func (t *RouteWalkerTool) Click(l game.Location) game.ModMap {
 	t.a = l
-	t.color = game.RandomColor()
+	t.color = game.Color{
+		R: uint8(toolRand.Intn(255)),
+		G: uint8(toolRand.Intn(255)),
+		B: uint8(toolRand.Intn(255)),
+		A: 255,
+	}
 	return nil
 }
 
@@ -394,13 +425,14 @@ func (t *RouteWalkerTool) RightClick(l game.Location) game.ModMap {
 	}
 	sc := layer.NewStackCursor(l)
 	for i := 0; i < 1000; i++ {
-		l := l.JustOffset(rand.Intn(100)-50, rand.Intn(100)-50)
+		l := l.JustOffset(toolRand.Intn(100)-50, toolRand.Intn(100)-50)
 		sc.MoveTo(l)
 		if myrid := t.w.RoomIds.Get(l); myrid != rid {
 			// only spawn in room 'rid'
 			continue
 		}
-		t.w.Spawn(entity.NewRouteWalker(l, t.a, t.color))
+		// the walkers share a single FlowField rather than each finding a Route
+		t.w.Spawn(entity.NewFlowWalker(l, path.ToLocation(t.a), t.color))
 	}
 	return nil
 }
@@ -427,7 +459,7 @@ func (t *ConwayTool) Preview(l game.Location) (c <-chan game.Location, color gam
 
 func (t *ConwayTool) Click(l game.Location) game.ModMap {
 	for i := 0; i < 800; i++ {
-		l := l.JustOffset(rand.Intn(50)-10, rand.Intn(50)-10)
+		l := l.JustOffset(toolRand.Intn(50)-10, toolRand.Intn(50)-10)
 		t.w.Spawn(entity.NewConwayCell(l))
 	}
 	return nil
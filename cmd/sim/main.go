@@ -0,0 +1,246 @@
+// Command sim runs a World without SDL, and reports how long it took to Think.
+//
+// The World is built by generate.NewGridWorld, or loaded from a snapshot
+// written by World.Save. A population of entities is spawned at random
+// locations in its rooms, then the World Thinks for -ticks ticks, or until
+// -budget has elapsed. ThinkStats and runstat metrics are printed as text, or
+// as JSON with -json.
+//
+// With -record, the movement of the entities is recorded by a world.Recorder
+// and written to a file when the run ends, as CSV if the file name ends in
+// .csv and as JSON lines otherwise.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"jds/game"
+	"jds/game/entity"
+	"jds/game/world"
+	"jds/game/world/generate"
+	"jds/runstat"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	// Snapshot to load. If empty, a grid world is generated
+	Load string
+	// Grid world dimensions, in rooms, and seed
+	Width, Height int
+	Seed          int64
+	// Population to spawn
+	RouteWalkers, RandomWalkers, ConwayCells int
+	// Think for Ticks ticks, or until Budget has elapsed if it is not zero
+	Ticks  int
+	Budget time.Duration
+	// Record movement to this file, keeping the last RecordSize events
+	Record     string
+	RecordSize int
+}
+
+type Metric struct {
+	Count               int
+	Average, Max, Total time.Duration
+}
+
+type Report struct {
+	Ticks    game.Tick
+	Entities int
+	Elapsed  time.Duration
+	// Copy of World.ThinkStats
+	Actions, Workers, Jobs, JobQueue int
+	ThinkElapsed                     time.Duration
+	// runstat metrics
+	Metrics map[string]Metric
+}
+
+// Returns the World described by c, with its population spawned. rec, if not
+// nil, is set as the World's Recorder before spawning, so that it sees the
+// spawns.
+func (c Config) World(rec *world.Recorder) (*world.World, error) {
+	var w *world.World
+	if c.Load != "" {
+		f, err := os.Open(c.Load)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if w, err = world.Load(f); err != nil {
+			return nil, err
+		}
+	} else {
+		w = generate.NewGridWorld(c.Width, c.Height, c.Seed)
+	}
+	if rec != nil {
+		w.SetRecorder(rec)
+	}
+	rng := world.NewRand(uint64(w.Seed()))
+	rl := roomLocations(w, &rng)
+	for i := 0; i < c.RouteWalkers; i++ {
+		color := game.Color{
+			R: uint8(rng.Intn(255)),
+			G: uint8(rng.Intn(255)),
+			B: uint8(rng.Intn(255)),
+			A: 255,
+		}
+		w.Spawn(entity.NewRouteWalker(rl(), rl(), color))
+	}
+	for i := 0; i < c.RandomWalkers; i++ {
+		w.Spawn(entity.NewRandomWalker(rl()))
+	}
+	for i := 0; i < c.ConwayCells; i++ {
+		w.Spawn(entity.NewConwayCell(rl()))
+	}
+	return w, nil
+}
+
+// Returns a function that returns random locations inside the rooms of w
+func roomLocations(w *world.World, rng *world.Rand) func() game.Location {
+	bids := w.RoomIds.NonZeroBlocks()
+	// Sort, so that the same seed gives the same locations
+	sort.Slice(bids, func(i, j int) bool {
+		if bids[i].Y != bids[j].Y {
+			return bids[i].Y < bids[j].Y
+		}
+		return bids[i].X < bids[j].X
+	})
+	return func() game.Location {
+		if len(bids) == 0 {
+			panic("world has no rooms")
+		}
+		for {
+			l := game.Location{
+				BlockId: bids[rng.Intn(len(bids))],
+				X:       int8(rng.Intn(game.BLOCK_SIZE)),
+				Y:       int8(rng.Intn(game.BLOCK_SIZE)),
+			}
+			if w.RoomIds.Get(l) != 0 && w.Walls.Get(l) == 0 {
+				return l
+			}
+		}
+	}
+}
+
+// Writes the events recorded by r to c.Record
+func (c Config) WriteRecord(r *world.Recorder) error {
+	f, err := os.Create(c.Record)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(c.Record, ".csv") {
+		err = r.WriteCSV(f)
+	} else {
+		err = r.WriteJSONLines(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Thinks until c.Ticks ticks or c.Budget have elapsed, and reports the
+// statistics
+func (c Config) Run(w *world.World) Report {
+	start := time.Now()
+	startTick := w.Now()
+	for i := 0; i < c.Ticks; i++ {
+		if c.Budget != 0 && time.Since(start) >= c.Budget {
+			break
+		}
+		w.Think()
+	}
+	r := Report{
+		Ticks:        w.Now() - startTick,
+		Entities:     len(w.Entities),
+		Elapsed:      time.Since(start),
+		Actions:      w.ThinkStats.Actions,
+		Workers:      w.ThinkStats.Workers,
+		Jobs:         w.ThinkStats.Jobs,
+		JobQueue:     w.ThinkStats.JobQueue,
+		ThinkElapsed: w.ThinkStats.Elapsed,
+		Metrics:      make(map[string]Metric),
+	}
+	for k, m := range runstat.Metrics {
+		r.Metrics[k] = Metric{
+			Count:   m.Count,
+			Average: m.Average(),
+			Max:     m.Max,
+			Total:   m.Total,
+		}
+	}
+	return r
+}
+
+func (r Report) Print() {
+	fmt.Println("Ticks:", r.Ticks)
+	fmt.Println("Entities:", r.Entities)
+	fmt.Println("Elapsed:", r.Elapsed)
+	if r.Ticks != 0 {
+		fmt.Println("Avg workers per tick:", float32(r.Workers)/float32(r.Ticks))
+		fmt.Println("Avg time per Think:", r.ThinkElapsed/time.Duration(r.Ticks))
+		fmt.Println("Avg jobs per tick:", float32(r.Jobs)/float32(r.Ticks))
+	}
+	fmt.Println("Jobs queued:", r.JobQueue)
+	if r.Workers != 0 {
+		fmt.Println("Avg Actions per worker:", float32(r.Actions)/float32(r.Workers))
+	}
+	if r.ThinkElapsed != 0 {
+		fmt.Println("Avg Actions per second:", float64(r.Actions)/r.ThinkElapsed.Seconds())
+	}
+	metricNames := make([]string, 0, len(r.Metrics))
+	for k := range r.Metrics {
+		metricNames = append(metricNames, k)
+	}
+	sort.Strings(metricNames)
+	for _, k := range metricNames {
+		m := r.Metrics[k]
+		fmt.Println(k, m.Average, "max:", m.Max, "total:", m.Total)
+	}
+}
+
+func main() {
+	var c Config
+	flag.StringVar(&c.Load, "load", "", "load the World from this snapshot instead of generating it")
+	flag.IntVar(&c.Width, "width", 50, "width of the generated grid world, in rooms")
+	flag.IntVar(&c.Height, "height", 50, "height of the generated grid world, in rooms")
+	flag.Int64Var(&c.Seed, "seed", time.Now().UnixNano(), "seed of the generated grid world")
+	flag.IntVar(&c.RouteWalkers, "routewalkers", 10000, "number of RouteWalkers to spawn")
+	flag.IntVar(&c.RandomWalkers, "randomwalkers", 0, "number of RandomWalkers to spawn")
+	flag.IntVar(&c.ConwayCells, "conway", 0, "number of ConwayCells to spawn")
+	flag.IntVar(&c.Ticks, "ticks", 1000, "number of ticks to run")
+	flag.DurationVar(&c.Budget, "budget", 0, "stop after this much wall-clock time (0 for no limit)")
+	flag.StringVar(&c.Record, "record", "", "record entity movement to this file, as CSV if it ends in .csv, else JSON lines")
+	flag.IntVar(&c.RecordSize, "recordsize", 1<<20, "number of movement events to keep when recording")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+	var rec *world.Recorder
+	if c.Record != "" {
+		rec = world.NewRecorder(c.RecordSize)
+	}
+	w, err := c.World(rec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	r := c.Run(w)
+	if rec != nil {
+		if err := c.WriteRecord(rec); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(r); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	r.Print()
+}
@@ -1,6 +1,8 @@
 package entity
 
 import (
+	"bytes"
+	"encoding/binary"
 	"jds/game"
 	"jds/game/layer"
 	"jds/game/world"
@@ -16,6 +18,8 @@ type ConwayCell struct {
 	l         game.Location
 	sc        *layer.StackCursor
 	spawntick game.Tick
+	next      game.Tick // tick of the pending Act or die
+	dying     bool      // die, not Act, is pending
 }
 
 var cellPool sync.Pool
@@ -40,6 +44,16 @@ func (t *ConwayCell) Spawned(ta *world.ActionAccumulator, id world.EntityId, w *
 	t.id = id
 	t.w = w
 	t.sc = sc
+	t.addLayer()
+	t.schedule(ta, (w.Now()/2+1)*2, false)
+	if w.Now() != t.spawntick && t.spawntick != 0 {
+		panic("spawntick is wrong")
+	}
+}
+
+// Adds the Conway layer to t.sc and counts t as a neighbor of the
+// surrounding tiles
+func (t *ConwayCell) addLayer() {
 	if t.sc.Add(t.w.CustomLayer("Conway")) != conwayLayer {
 		panic("unexpected layer id")
 	}
@@ -47,10 +61,16 @@ func (t *ConwayCell) Spawned(ta *world.ActionAccumulator, id world.EntityId, w *
 		d := game.Direction(d)
 		t.sc.DirectedSet(conwayLayer, d, v+1)
 	}
-	nexttick := (w.Now()/2 + 1) * 2
-	ta.Add(nexttick, t.Act, t.l.BlockId)
-	if w.Now() != t.spawntick && t.spawntick != 0 {
-		panic("spawntick is wrong")
+}
+
+// Schedule t.die or t.Act for tick 'at'
+func (t *ConwayCell) schedule(ta *world.ActionAccumulator, at game.Tick, dying bool) {
+	t.next = at
+	t.dying = dying
+	if dying {
+		ta.AddKeyed("step", at, t.die, t.l.BlockId)
+	} else {
+		ta.AddKeyed("step", at, t.Act, t.l.BlockId)
 	}
 }
 
@@ -67,6 +87,15 @@ func (t *ConwayCell) HitWall(d game.Direction) {
 
 func (t *ConwayCell) die(ta *world.ActionAccumulator) {
 	ta.Kill(t.id)
+}
+
+// Stops counting t as a neighbor, and recycles it
+func (t *ConwayCell) Despawned(ta *world.ActionAccumulator) {
+	if t.sc == nil {
+		// Killed before Spawned ran, so it was never counted
+		cellPool.Put(t)
+		return
+	}
 	for d, v := range t.sc.Look(conwayLayer) {
 		d := game.Direction(d)
 		t.sc.DirectedSet(conwayLayer, d, v-1)
@@ -79,10 +108,10 @@ func (t *ConwayCell) Act(ta *world.ActionAccumulator) {
 	// Conway's rules
 	if neighbors <= 1 || neighbors >= 4 {
 		// Die
-		ta.Add(t.w.Now()+1, t.die, t.l.BlockId)
+		t.schedule(ta, t.w.Now()+1, true)
 	} else { // 2 or 3 neighbors
 		// Survive until next World tick
-		ta.Add(t.w.Now()+2, t.Act, t.l.BlockId)
+		t.schedule(ta, t.w.Now()+2, false)
 	}
 	// Spawn new cell in an empty neighboring location if it has exactly 3
 	// neighboring cells
@@ -96,8 +125,11 @@ func (t *ConwayCell) Act(ta *world.ActionAccumulator) {
 		}
 		if conwayLocal[d] == 3 {
 			c := cellPool.Get().(*ConwayCell)
-			c.l = nl
-			c.spawntick = t.w.Now() + 1
+			// reset state left over from the cell's previous life
+			*c = ConwayCell{
+				l:         nl,
+				spawntick: t.w.Now() + 1,
+			}
 			ta.Spawn(c)
 		}
 	}
@@ -111,3 +143,42 @@ func (t *ConwayCell) Color() game.Color {
 		A: 255,
 	}
 }
+
+// Encoded ConwayCell
+type conwayCellRecord struct {
+	L     locationRecord
+	Next  int64
+	Dying bool
+}
+
+func (t *ConwayCell) EntityType() string {
+	return "entity.ConwayCell"
+}
+
+func (t *ConwayCell) Marshal() ([]byte, error) {
+	return marshal(conwayCellRecord{
+		L:     newLocationRecord(t.l),
+		Next:  int64(t.next),
+		Dying: t.dying,
+	})
+}
+
+func (t *ConwayCell) Unmarshal(data []byte) error {
+	var rec conwayCellRecord
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &rec); err != nil {
+		return err
+	}
+	t.l = rec.L.Location()
+	t.next = game.Tick(rec.Next)
+	t.dying = rec.Dying
+	return nil
+}
+
+// Restores t's neighbor counts and pending Act or die
+func (t *ConwayCell) Restored(ta *world.ActionAccumulator, id world.EntityId, w *world.World, sc *layer.StackCursor) {
+	t.id = id
+	t.w = w
+	t.sc = sc
+	t.addLayer()
+	t.schedule(ta, t.next, t.dying)
+}
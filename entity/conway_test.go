@@ -32,6 +32,20 @@ func TestConwayCell(t *testing.T) {
 	w.Discard()
 }
 
+func TestConwayCellKilledBeforeSpawned(t *testing.T) {
+	w := world.NewWorld(0)
+	l := game.Location{}
+	id := w.Spawn(NewConwayCell(l))
+	if !w.Kill(id) {
+		t.Fatal("cell wasn't spawned")
+	}
+	w.Think()
+	if len(w.Entities) != 0 {
+		t.Error("killed cell came back")
+	}
+	w.Discard()
+}
+
 func BenchmarkConwayCell(b *testing.B) {
 	b.StopTimer()
 	w := world.NewWorld(0)
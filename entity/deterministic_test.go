@@ -0,0 +1,85 @@
+package entity
+
+import (
+	"bytes"
+	"jds/game"
+	"jds/game/world"
+	"runtime"
+	"testing"
+)
+
+// Builds a seeded World full of entities
+func seededWorld(seed int64) *world.World {
+	w := world.NewWorld(0)
+	w.SetSeed(seed)
+	rng := world.NewRand(uint64(seed))
+	origin := game.Location{}
+	for i := 0; i < 4; i++ {
+		w.DrawBox(origin.JustOffset(40*i, 0), origin.JustOffset(40*i+40, 40))
+	}
+	for i := 1; i < 4; i++ {
+		w.NewDoor(origin.JustOffset(40*i-1, 18), world.VERT, nil)
+	}
+	rl := func() game.Location {
+		return origin.JustOffset(1+rng.Intn(158), 1+rng.Intn(38))
+	}
+	for i := 0; i < 200; i++ {
+		w.Spawn(NewRouteWalker(rl(), rl(), game.Color{}))
+		w.Spawn(NewRandomWalker(rl()))
+		w.Spawn(NewConwayCell(rl()))
+	}
+	return w
+}
+
+func TestDeterministic(t *testing.T) {
+	w1, w2 := seededWorld(1), seededWorld(1)
+	defer w1.Discard()
+	defer w2.Discard()
+	var b1, b2 bytes.Buffer
+	for i := 0; i < 100; i++ {
+		w1.Think()
+		w2.Think()
+		b1.Reset()
+		b2.Reset()
+		if err := w1.Save(&b1); err != nil {
+			t.Fatal(err)
+		}
+		w2.Save(&b2)
+		if !bytes.Equal(b1.Bytes(), b2.Bytes()) {
+			t.Fatal("worlds with the same seed differ at tick", w1.Now())
+		}
+	}
+	// A different seed gives a different world
+	w3 := seededWorld(2)
+	defer w3.Discard()
+	for i := 0; i < 100; i++ {
+		w3.Think()
+	}
+	var b3 bytes.Buffer
+	w3.Save(&b3)
+	if bytes.Equal(b1.Bytes(), b3.Bytes()) {
+		t.Error("worlds with different seeds are the same")
+	}
+}
+
+func TestDeterministicWorkers(t *testing.T) {
+	// One worker at a time
+	procs := runtime.GOMAXPROCS(1)
+	w := seededWorld(3)
+	hashes := make([]uint64, 100)
+	for i := range hashes {
+		w.Think()
+		hashes[i] = w.Hash()
+	}
+	w.Discard()
+	// Many workers
+	runtime.GOMAXPROCS(procs)
+	w = seededWorld(3)
+	defer w.Discard()
+	for _, h := range hashes {
+		w.Think()
+		if w.Hash() != h {
+			t.Fatal("hash with", procs, "workers differs at tick", w.Now())
+		}
+	}
+}
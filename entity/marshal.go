@@ -0,0 +1,59 @@
+// Saving and restoring entities in World snapshots
+
+package entity
+
+import (
+	"bytes"
+	"encoding/binary"
+	"jds/game"
+	"jds/game/world"
+)
+
+func init() {
+	world.RegisterEntity("entity.RouteWalker", func() world.EntityUnmarshaler {
+		return new(RouteWalker)
+	})
+	world.RegisterEntity("entity.ConwayCell", func() world.EntityUnmarshaler {
+		return new(ConwayCell)
+	})
+	world.RegisterEntity("entity.RandomWalker", func() world.EntityUnmarshaler {
+		return new(RandomWalker)
+	})
+	world.RegisterEntity("entity.Patron", func() world.EntityUnmarshaler {
+		return new(Patron)
+	})
+}
+
+// Encoded game.Location
+type locationRecord struct {
+	BX, BY int64
+	X, Y   int8
+}
+
+func newLocationRecord(l game.Location) locationRecord {
+	return locationRecord{
+		BX: int64(l.BlockId.X),
+		BY: int64(l.BlockId.Y),
+		X:  l.X,
+		Y:  l.Y,
+	}
+}
+
+func (r locationRecord) Location() game.Location {
+	return game.Location{
+		BlockId: game.BlockId{X: int(r.BX), Y: int(r.BY)},
+		X:       r.X,
+		Y:       r.Y,
+	}
+}
+
+// Encodes the values in v, in order
+func marshal(v ...interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, x := range v {
+		if err := binary.Write(&buf, binary.LittleEndian, x); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
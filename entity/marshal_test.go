@@ -0,0 +1,164 @@
+package entity
+
+import (
+	"bytes"
+	"jds/game"
+	"jds/game/world"
+	"jds/game/world/path"
+	"math/rand"
+	"testing"
+)
+
+// Saves w and loads it into a new World. Fails if saving the new World
+// doesn't give the same snapshot.
+func reload(t *testing.T, w *world.World) *world.World {
+	var buf bytes.Buffer
+	if err := w.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	saved := append([]byte(nil), buf.Bytes()...)
+	w2, err := world.Load(bytes.NewReader(saved))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := w2.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, buf.Bytes()) {
+		t.Error("re-saved snapshot differs")
+	}
+	if !w.EntityIds.Equal(w2.EntityIds) || len(w.Entities) != len(w2.Entities) {
+		t.Error("entities differ after load")
+	}
+	return w2
+}
+
+func TestSaveLoadRouteWalkers(t *testing.T) {
+	w := world.NewWorld(0)
+	defer w.Discard()
+	origin := game.Location{}
+	for i := 0; i < 3; i++ {
+		w.DrawBox(origin.JustOffset(20*i, 0), origin.JustOffset(20*i+20, 20))
+	}
+	for i := 1; i < 3; i++ {
+		if w.NewDoor(origin.JustOffset(20*i-1, 8), world.VERT, nil) == nil {
+			t.Fatal("couldn't place door")
+		}
+	}
+	for i := 0; i < 20; i++ {
+		l := origin.JustOffset(1+rand.Intn(18), 1+rand.Intn(18))
+		dest := origin.JustOffset(41+rand.Intn(18), 1+rand.Intn(18))
+		w.Spawn(NewRouteWalker(l, dest, game.RandomColor()))
+	}
+	// and some following a FlowField
+	right := path.ToRoom(world.RoomId(w.RoomIds.Get(origin.JustOffset(50, 10))))
+	for i := 0; i < 10; i++ {
+		l := origin.JustOffset(1+rand.Intn(18), 1+rand.Intn(18))
+		w.Spawn(NewFlowWalker(l, right, game.RandomColor()))
+	}
+	for i := 0; i < 10; i++ {
+		w.Think()
+	}
+	w2 := reload(t, w)
+	defer w2.Discard()
+	if !w.CustomLayer("RouteWalkerIntentions").Equal(w2.CustomLayer("RouteWalkerIntentions")) {
+		t.Error("intentions differ after load")
+	}
+	// Restored walkers carry on to their destinations
+	for i := 0; i < 1000 && len(w2.Entities) > 0; i++ {
+		w2.Think()
+	}
+	if len(w2.Entities) != 0 {
+		t.Error(len(w2.Entities), "walkers didn't arrive")
+	}
+}
+
+func TestSaveLoadConwayCells(t *testing.T) {
+	w := world.NewWorld(0)
+	defer w.Discard()
+	l := game.Location{}
+	for i := 0; i < 1000; i++ {
+		w.Spawn(NewConwayCell(l.JustOffset(rand.Intn(50), rand.Intn(50))))
+	}
+	// Save at both odd and even ticks, so that pending Acts and dies are
+	// both restored
+	for i := 0; i < 2; i++ {
+		w.Think()
+		w2 := reload(t, w)
+		conway, conway2 := w.CustomLayer("Conway"), w2.CustomLayer("Conway")
+		if !conway.Equal(conway2) {
+			t.Error("neighbor counts differ after load")
+		}
+		for j := 0; j < 10; j++ {
+			w.Think()
+			w2.Think()
+		}
+		if !conway.Equal(conway2) || len(w.Entities) != len(w2.Entities) {
+			t.Error("restored cells diverged")
+		}
+		w2.Discard()
+	}
+}
+
+func TestSaveLoadRandomWalker(t *testing.T) {
+	w := world.NewWorld(0)
+	defer w.Discard()
+	l := game.Location{}
+	w.DrawBox(l, l.JustOffset(10, 10))
+	eid := w.Spawn(NewRandomWalker(l.JustOffset(5, 5)))
+	for i := 0; i < 20; i++ {
+		w.Think()
+	}
+	w2 := reload(t, w)
+	defer w2.Discard()
+	e, e2 := w.Entities[eid].(*RandomWalker), w2.Entities[eid].(*RandomWalker)
+	if e2.l != e.l || e2.spawned != e.spawned || e2.touched != e.touched || e2.hitWall != e.hitWall {
+		t.Error("random walker not restored")
+	}
+	steps := e2.touched + e2.hitWall
+	moved := false
+	for i := 0; i < 20; i++ {
+		w2.Think()
+		moved = moved || e2.l != e.l
+	}
+	if !moved && e2.touched+e2.hitWall == steps {
+		t.Error("restored random walker didn't act")
+	}
+}
+
+func TestSaveLoadPatrons(t *testing.T) {
+	w, rooms := mall(t)
+	defer w.Discard()
+	for i := 0; i < 10; i++ {
+		p := NewPatron(rooms[0].JustOffset(i-5, 5), rooms[0], 100, []Need{NEED_CLOTHING})
+		p.Hunger = float64(i) / 10
+		w.Spawn(p)
+	}
+	// Save while some Patrons are walking, and some are visiting
+	for i := 0; i < 40; i++ {
+		w.Think()
+	}
+	w2 := reload(t, w)
+	defer w2.Discard()
+	SetDirectory(w2, directory(w))
+	states := make(map[patronState]int)
+	for eid, e := range w.Entities {
+		p, p2 := e.(*Patron), w2.Entities[eid].(*Patron)
+		if p.state != p2.state || p.next != p2.next || p.Budget != p2.Budget ||
+			p.Hunger != p2.Hunger || len(p.Shopping) != len(p2.Shopping) || p.walker.l != p2.walker.l {
+			t.Error("patron not restored")
+		}
+		states[p.state]++
+	}
+	if states[PATRON_WALKING] == 0 || states[PATRON_VISITING] == 0 {
+		t.Error("expected walking and visiting patrons, got", states)
+	}
+	// Restored Patrons finish shopping and leave
+	for i := 0; i < 2000 && len(w2.Entities) > 0; i++ {
+		w2.Think()
+	}
+	if len(w2.Entities) != 0 {
+		t.Error(len(w2.Entities), "patrons didn't leave")
+	}
+}
@@ -1,4 +1,423 @@
+// Patrons visit the shops of the mall to satisfy their needs, then leave
+
 package entity
 
+import (
+	"bytes"
+	"encoding/binary"
+	"jds/game"
+	"jds/game/layer"
+	"jds/game/world"
+	"jds/game/world/path"
+)
+
+// Something a Patron wants from a shop
+type Need int
+
+const (
+	NEED_FOOD Need = iota
+	NEED_CLOTHING
+	NEED_ELECTRONICS
+	NEED_GROCERIES
+	NUM_NEEDS
+)
+
+// What satisfying each Need costs
+var NeedCost = [NUM_NEEDS]int{
+	NEED_FOOD:        10,
+	NEED_CLOTHING:    40,
+	NEED_ELECTRONICS: 80,
+	NEED_GROCERIES:   20,
+}
+
+const (
+	// Hunger gained per tick
+	HUNGER_RATE = 0.001
+	// Patrons with at least this much Hunger look for food first
+	HUNGRY = 0.5
+	// A visit to a shop lasts between VISIT_TICKS and 2*VISIT_TICKS ticks
+	VISIT_TICKS = 20
+)
+
+// Finds shops for Patrons
+type Directory interface {
+	// Returns a Location in a room that satisfies need n, for a Patron at
+	// from, or false if there is none. Called during Think, so it must be
+	// safe for concurrent use.
+	Find(n Need, from game.Location, rng *world.Rand) (game.Location, bool)
+}
+
+// A Directory with a fixed list of rooms for each Need. Each room is given
+// by a Location inside it.
+type RoomList map[Need][]game.Location
+
+// Returns a random room for n
+func (rl RoomList) Find(n Need, from game.Location, rng *world.Rand) (game.Location, bool) {
+	rooms := rl[n]
+	if len(rooms) == 0 {
+		return game.Location{}, false
+	}
+	return rooms[rng.Intn(len(rooms))], true
+}
+
+// The ShopCategory of the rooms that satisfy each Need
+var NeedCategory = [NUM_NEEDS]world.ShopCategory{
+	NEED_FOOD:        world.SHOP_FOOD,
+	NEED_CLOTHING:    world.SHOP_CLOTHING,
+	NEED_ELECTRONICS: world.SHOP_ELECTRONICS,
+	NEED_GROCERIES:   world.SHOP_GROCERIES,
+}
+
+// A Directory of the rooms of a World zoned for each Need, see
+// world.SetZoning
+type ZonedRooms struct {
+	W *world.World
+}
+
+// Returns a random room zoned for n that is open and not full
+func (z ZonedRooms) Find(n Need, from game.Location, rng *world.Rand) (game.Location, bool) {
+	now := z.W.Now()
+	var open []world.RoomId
+	for _, rid := range z.W.ZonedRooms(NeedCategory[n]) {
+		zoning, _ := z.W.Zoning(rid)
+		if zoning.OpenAt(now) && (zoning.Capacity == 0 || z.W.Occupancy(rid) < zoning.Capacity) {
+			open = append(open, rid)
+		}
+	}
+	if len(open) == 0 {
+		return game.Location{}, false
+	}
+	ok, l := z.W.Rooms[open[rng.Intn(len(open))]].IsNonEmpty()
+	return l, ok
+}
+
+type directoryHolder struct {
+	d Directory
+}
+
+func holder(w *world.World) *directoryHolder {
+	return w.CustomData("entity.Directory", func() interface{} {
+		return &directoryHolder{}
+	}).(*directoryHolder)
+}
+
+// Sets the Directory the Patrons of w use to find shops. Must not be called
+// during Think.
+func SetDirectory(w *world.World, d Directory) {
+	holder(w).d = d
+}
+
+// Returns the Directory of w, or nil
+func directory(w *world.World) Directory {
+	return holder(w).d
+}
+
+type patronState int
+
+const (
+	// Walking to a shop, or waiting to start
+	PATRON_WALKING patronState = iota
+	// In a shop
+	PATRON_VISITING
+	// Walking to the exit
+	PATRON_LEAVING
+)
+
+// A Patron picks the Need it wants most, finds a shop for it in the World's
+// Directory, walks there like a RouteWalker, and spends a while inside. When
+// it has nothing left to buy, can't afford anything, or runs out of Patience,
+// it walks to the room containing its exit and disappears.
 type Patron struct {
+	walker RouteWalker
+	exit   game.Location
+	state  patronState
+	goal   Need      // Need being satisfied, when walking or visiting
+	last   game.Tick // Hunger was last updated at this tick
+	next   game.Tick // tick of the pending Act, 0 if none
+	// Money left to spend
+	Budget int
+	// Increases by HUNGER_RATE each tick, and is reset by eating
+	Hunger float64
+	// Needs other than food, in order of preference
+	Shopping []Need
+	// Number of times the Patron will fail to find or reach a shop before
+	// giving up and leaving
+	Patience int
+}
+
+func NewPatron(l, exit game.Location, budget int, shopping []Need) *Patron {
+	return &Patron{
+		walker: RouteWalker{
+			l:    l,
+			dest: l,
+		},
+		exit:     exit,
+		Budget:   budget,
+		Shopping: shopping,
+		Patience: 3,
+	}
+}
+
+func (p *Patron) Location() game.Location {
+	return p.walker.l
+}
+
+func (p *Patron) Spawned(ta *world.ActionAccumulator, id world.EntityId, w *world.World, sc *layer.StackCursor) {
+	p.walker.init(id, w, sc)
+	p.walker.goal = p
+	p.walker.cost = path.Costs(w)
+	p.last = w.Now()
+	p.decide(ta)
+}
+
+func (p *Patron) Despawned(ta *world.ActionAccumulator) {
+	p.walker.Despawned(ta)
+}
+
+func (p *Patron) Touched(other world.EntityId, d game.Direction) {
+}
+
+func (p *Patron) HitWall(d game.Direction) {
+}
+
+func (p *Patron) Color() game.Color {
+	switch p.state {
+	case PATRON_VISITING:
+		return game.Color{R: 0, G: 0, B: 255, A: 255}
+	case PATRON_LEAVING:
+		return game.Color{R: 255, G: 0, B: 0, A: 255}
+	default:
+		return game.Color{R: 0, G: 255, B: 0, A: 255}
+	}
+}
+
+// The walk is over once the Patron is in the same room as its destination
+func (p *Patron) Reached(l game.Location) bool {
+	rid := p.walker.w.RoomIds.Get(p.walker.dest)
+	return rid != 0 && p.walker.w.RoomIds.Get(l) == rid
+}
+
+func (p *Patron) Arrived(ta *world.ActionAccumulator) {
+	if p.state == PATRON_LEAVING {
+		p.walker.die(ta)
+		return
+	}
+	p.visit(ta)
+}
+
+// Walls now block the way, so the Patron gives up on its goal
+func (p *Patron) Lost(ta *world.ActionAccumulator) {
+	p.Patience--
+	p.decide(ta)
+}
+
+// Starts a visit to the shop the Patron is in
+func (p *Patron) visit(ta *world.ActionAccumulator) {
+	rng := p.walker.w.EntityRand(p.walker.id)
+	p.state = PATRON_VISITING
+	p.schedule(ta, p.walker.w.Now()+VISIT_TICKS+game.Tick(rng.Intn(VISIT_TICKS)))
+}
+
+func (p *Patron) Act(ta *world.ActionAccumulator) {
+	p.next = 0
+	if p.state == PATRON_VISITING {
+		// The visit is over
+		p.Budget -= NeedCost[p.goal]
+		if p.goal == NEED_FOOD {
+			p.Hunger = 0
+		} else {
+			p.drop(p.goal)
+		}
+		p.state = PATRON_WALKING
+	}
+	p.decide(ta)
+}
+
+// Schedule p.Act for tick 'at'
+func (p *Patron) schedule(ta *world.ActionAccumulator, at game.Tick) {
+	p.next = at
+	ta.AddKeyed("patron", at, p.Act, p.walker.l.BlockId)
+}
+
+// Removes n from the shopping list
+func (p *Patron) drop(n Need) {
+	for i := range p.Shopping {
+		if p.Shopping[i] == n {
+			p.Shopping = append(p.Shopping[:i], p.Shopping[i+1:]...)
+			return
+		}
+	}
+}
+
+// Returns the affordable Needs, most wanted first
+func (p *Patron) needs() (needs []Need) {
+	if p.Hunger >= HUNGRY && p.Budget >= NeedCost[NEED_FOOD] {
+		needs = append(needs, NEED_FOOD)
+	}
+	for _, n := range p.Shopping {
+		if p.Budget >= NeedCost[n] {
+			needs = append(needs, n)
+		}
+	}
+	return
+}
+
+// Picks the next goal and starts walking to it
+func (p *Patron) decide(ta *world.ActionAccumulator) {
+	now := p.walker.w.Now()
+	p.Hunger += HUNGER_RATE * float64(now-p.last)
+	p.last = now
+	p.seek(ta, p.needs())
+}
+
+// Starts walking to a shop for the first of needs that has one. If the shop
+// turns out to be unreachable, seeks the rest of needs.
+func (p *Patron) seek(ta *world.ActionAccumulator, needs []Need) {
+	rng := p.walker.w.EntityRand(p.walker.id)
+	d := directory(p.walker.w)
+	for i, n := range needs {
+		if p.state == PATRON_LEAVING || p.Patience <= 0 || d == nil {
+			break
+		}
+		dest, ok := d.Find(n, p.walker.l, &rng)
+		if !ok {
+			p.Patience--
+			continue
+		}
+		p.goal = n
+		rest := needs[i+1:]
+		p.walk(ta, dest, func(ta *world.ActionAccumulator) {
+			p.Patience--
+			p.seek(ta, rest)
+		})
+		return
+	}
+	p.state = PATRON_LEAVING
+	p.walk(ta, p.exit, p.unreachable)
+}
+
+// Called when the room the Patron is walking to can't be reached
+func (p *Patron) unreachable(ta *world.ActionAccumulator) {
+	if p.state == PATRON_LEAVING {
+		// No way out
+		p.walker.die(ta)
+		return
+	}
+	p.Lost(ta)
+}
+
+// Starts walking to the room containing dest. Its route is found in a job
+// between ticks, see path.Request, and lost is called instead if the room
+// can't be reached.
+func (p *Patron) walk(ta *world.ActionAccumulator, dest game.Location, lost world.Action) {
+	p.walker.dest = dest
+	if p.Reached(p.walker.l) {
+		// Already there
+		p.Arrived(ta)
+		return
+	}
+	p.walker.waiting = true
+	path.Request(ta, p.walker.w, p.walker.l, dest, p.walker.cost, p.walker.l.BlockId, func(ta *world.ActionAccumulator, route path.Route) {
+		p.walker.waiting = false
+		if route.Len() == 0 {
+			lost(ta)
+			return
+		}
+		p.begin(ta, route)
+	})
+}
+
+// Starts walking along route. If another walker is passing through, tries
+// again next tick.
+func (p *Patron) begin(ta *world.ActionAccumulator, route path.Route) {
+	if p.walker.walkTo(ta, p.walker.dest, route) {
+		return
+	}
+	ta.AddKeyed("patron", p.walker.w.Now()+1, func(ta *world.ActionAccumulator) {
+		p.begin(ta, route)
+	}, p.walker.l.BlockId)
+}
+
+// Encoded Patron, followed by ShoppingLen int32 Needs, and the encoded
+// RouteWalker
+type patronRecord struct {
+	Exit        locationRecord
+	State       int32
+	Goal        int32
+	Last        int64
+	Next        int64
+	Budget      int64
+	Hunger      float64
+	Patience    int64
+	ShoppingLen uint32
+}
+
+func (p *Patron) EntityType() string {
+	return "entity.Patron"
+}
+
+func (p *Patron) Marshal() ([]byte, error) {
+	walker, err := p.walker.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	shopping := make([]int32, len(p.Shopping))
+	for i, n := range p.Shopping {
+		shopping[i] = int32(n)
+	}
+	return marshal(patronRecord{
+		Exit:        newLocationRecord(p.exit),
+		State:       int32(p.state),
+		Goal:        int32(p.goal),
+		Last:        int64(p.last),
+		Next:        int64(p.next),
+		Budget:      int64(p.Budget),
+		Hunger:      p.Hunger,
+		Patience:    int64(p.Patience),
+		ShoppingLen: uint32(len(shopping)),
+	}, shopping, walker)
+}
+
+func (p *Patron) Unmarshal(data []byte) error {
+	var rec patronRecord
+	r := bytes.NewReader(data)
+	if err := binary.Read(r, binary.LittleEndian, &rec); err != nil {
+		return err
+	}
+	shopping := make([]int32, rec.ShoppingLen)
+	if err := binary.Read(r, binary.LittleEndian, shopping); err != nil {
+		return err
+	}
+	if err := p.walker.Unmarshal(data[len(data)-r.Len():]); err != nil {
+		return err
+	}
+	p.exit = rec.Exit.Location()
+	p.state = patronState(rec.State)
+	p.goal = Need(rec.Goal)
+	p.last = game.Tick(rec.Last)
+	p.next = game.Tick(rec.Next)
+	p.Budget = int(rec.Budget)
+	p.Hunger = rec.Hunger
+	p.Patience = int(rec.Patience)
+	p.Shopping = make([]Need, len(shopping))
+	for i, n := range shopping {
+		p.Shopping[i] = Need(n)
+	}
+	return nil
+}
+
+// Restores p's walk and pending Act, and requests its route again if it was
+// waiting for it
+func (p *Patron) Restored(ta *world.ActionAccumulator, id world.EntityId, w *world.World, sc *layer.StackCursor) {
+	p.walker.goal = p
+	p.walker.cost = path.Costs(w)
+	waiting := p.walker.waiting
+	p.walker.waiting = false
+	p.walker.Restored(ta, id, w, sc)
+	if p.next != 0 {
+		p.schedule(ta, p.next)
+	}
+	if waiting {
+		p.walk(ta, p.walker.dest, p.unreachable)
+	}
 }
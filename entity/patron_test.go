@@ -0,0 +1,84 @@
+package entity
+
+import (
+	"jds/game"
+	"jds/game/world"
+	"testing"
+)
+
+// Builds a row of 3 rooms joined by doors. The first is the entrance, the
+// second a food court, and the third a clothing store.
+func mall(t *testing.T) (w *world.World, rooms [3]game.Location) {
+	w = world.NewWorld(0)
+	w.SetSeed(1)
+	origin := game.Location{}
+	for i := 0; i < 3; i++ {
+		w.DrawBox(origin.JustOffset(20*i, 0), origin.JustOffset(20*i+20, 20))
+		rooms[i] = origin.JustOffset(20*i+10, 10)
+	}
+	for i := 1; i < 3; i++ {
+		if w.NewDoor(origin.JustOffset(20*i-1, 8), world.VERT, nil) == nil {
+			t.Fatal("couldn't place door")
+		}
+	}
+	SetDirectory(w, RoomList{
+		NEED_FOOD:     {rooms[1]},
+		NEED_CLOTHING: {rooms[2]},
+	})
+	return
+}
+
+func TestPatron(t *testing.T) {
+	w, rooms := mall(t)
+	defer w.Discard()
+	patrons := make([]*Patron, 0)
+	for i := 0; i < 10; i++ {
+		p := NewPatron(rooms[0].JustOffset(i-5, 5), rooms[0], 100, []Need{NEED_ELECTRONICS, NEED_CLOTHING})
+		p.Hunger = 1
+		w.Spawn(p)
+		patrons = append(patrons, p)
+	}
+	// A Patron who can't afford anything leaves at once
+	broke := NewPatron(rooms[0].JustOffset(0, -5), rooms[0], 5, []Need{NEED_CLOTHING})
+	w.Spawn(broke)
+	for i := 0; i < 2000 && len(w.Entities) > 0; i++ {
+		w.Think()
+	}
+	if len(w.Entities) != 0 {
+		t.Fatal(len(w.Entities), "patrons didn't leave")
+	}
+	for _, p := range patrons {
+		// There are no electronics shops, so Patience is lost once
+		if p.Budget != 100-NeedCost[NEED_FOOD]-NeedCost[NEED_CLOTHING] || p.Hunger >= HUNGRY || p.Patience != 2 {
+			t.Error("patron didn't shop:", p.Budget, p.Hunger, p.Patience, p.Shopping)
+		}
+	}
+	if broke.Budget != 5 || broke.Patience != 3 {
+		t.Error("broke patron shopped")
+	}
+}
+
+func TestZonedRooms(t *testing.T) {
+	w, rooms := mall(t)
+	defer w.Discard()
+	w.SetZoning(world.RoomId(w.RoomIds.Get(rooms[1])), world.Zoning{Category: world.SHOP_FOOD})
+	w.SetZoning(world.RoomId(w.RoomIds.Get(rooms[2])), world.Zoning{Category: world.SHOP_CLOTHING, Opens: 100, Closes: 200})
+	d := ZonedRooms{w}
+	rng := w.EntityRand(0)
+	if l, ok := d.Find(NEED_FOOD, rooms[0], &rng); !ok || w.RoomIds.Get(l) != w.RoomIds.Get(rooms[1]) {
+		t.Error("food court not found")
+	}
+	if _, ok := d.Find(NEED_CLOTHING, rooms[0], &rng); ok {
+		t.Error("found closed store")
+	}
+	if _, ok := d.Find(NEED_GROCERIES, rooms[0], &rng); ok {
+		t.Error("found nonexistent store")
+	}
+	// A full room isn't found
+	food := world.RoomId(w.RoomIds.Get(rooms[1]))
+	w.SetZoning(food, world.Zoning{Category: world.SHOP_FOOD, Capacity: 1})
+	w.Spawn(NewPatron(rooms[1], rooms[0], 0, nil))
+	if _, ok := d.Find(NEED_FOOD, rooms[0], &rng); ok || w.Occupancy(food) != 1 {
+		t.Error("found full food court")
+	}
+}
@@ -1,10 +1,11 @@
 package entity
 
 import (
+	"bytes"
+	"encoding/binary"
 	"jds/game"
 	"jds/game/layer"
 	"jds/game/world"
-	"math/rand"
 )
 
 type RandomWalker struct {
@@ -16,15 +17,7 @@ type RandomWalker struct {
 	spawned int
 	touched int
 	hitWall int
-}
-
-var randomTable [100]game.Direction
-var numSteps int
-
-func init() {
-	for i := range randomTable {
-		randomTable[i] = game.Direction(rand.Intn(8))
-	}
+	next    game.Tick // tick of the pending Act
 }
 
 func NewRandomWalker(l game.Location) *RandomWalker {
@@ -42,7 +35,7 @@ func (t *RandomWalker) Spawned(ta *world.ActionAccumulator, id world.EntityId, w
 	t.id = id
 	t.sc = sc
 	t.spawned++
-	ta.Add(t.w.Now()+1, t.Act, t.l.BlockId)
+	t.schedule(ta, t.w.Now()+1)
 }
 
 func (t *RandomWalker) Touched(other world.EntityId, d game.Direction) {
@@ -54,9 +47,15 @@ func (t *RandomWalker) HitWall(d game.Direction) {
 }
 
 func (t *RandomWalker) Act(ta *world.ActionAccumulator) {
-	numSteps++
-	t.l, _ = t.w.StepEntity(t.id, t, t.sc, randomTable[numSteps%100])
-	ta.Add(t.w.Now()+1, t.Act, t.l.BlockId)
+	rng := t.w.EntityRand(t.id)
+	t.l, _ = t.w.StepEntity(t.id, t, t.sc, game.Direction(rng.Intn(8)))
+	t.schedule(ta, t.w.Now()+1)
+}
+
+// Schedule t.Act for tick 'at'
+func (t *RandomWalker) schedule(ta *world.ActionAccumulator, at game.Tick) {
+	t.next = at
+	ta.AddKeyed("walk", at, t.Act, t.l.BlockId)
 }
 
 func (t *RandomWalker) Color() game.Color {
@@ -67,3 +66,47 @@ func (t *RandomWalker) Color() game.Color {
 		A: 255,
 	}
 }
+
+// Encoded RandomWalker
+type randomWalkerRecord struct {
+	L       locationRecord
+	Spawned int64
+	Touched int64
+	HitWall int64
+	Next    int64
+}
+
+func (t *RandomWalker) EntityType() string {
+	return "entity.RandomWalker"
+}
+
+func (t *RandomWalker) Marshal() ([]byte, error) {
+	return marshal(randomWalkerRecord{
+		L:       newLocationRecord(t.l),
+		Spawned: int64(t.spawned),
+		Touched: int64(t.touched),
+		HitWall: int64(t.hitWall),
+		Next:    int64(t.next),
+	})
+}
+
+func (t *RandomWalker) Unmarshal(data []byte) error {
+	var rec randomWalkerRecord
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &rec); err != nil {
+		return err
+	}
+	t.l = rec.L.Location()
+	t.spawned = int(rec.Spawned)
+	t.touched = int(rec.Touched)
+	t.hitWall = int(rec.HitWall)
+	t.next = game.Tick(rec.Next)
+	return nil
+}
+
+// Restores t's pending Act
+func (t *RandomWalker) Restored(ta *world.ActionAccumulator, id world.EntityId, w *world.World, sc *layer.StackCursor) {
+	t.w = w
+	t.id = id
+	t.sc = sc
+	t.schedule(ta, t.next)
+}
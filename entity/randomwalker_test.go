@@ -48,10 +48,20 @@ func TestRandomWalker(t *testing.T) {
 			continue
 		}
 	}
+	start := make([]game.Location, N)
+	for i := range E {
+		start[i] = E[i].l
+	}
 	for i := 0; i < 100; i++ {
 		w.Think()
 	}
-	if numSteps == 0 {
+	moved := 0
+	for i := range E {
+		if E[i].l != start[i] {
+			moved++
+		}
+	}
+	if moved == 0 {
 		t.Error("no steps taken")
 	}
 }
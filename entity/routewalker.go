@@ -1,14 +1,36 @@
 // RouteWalkers attempt to move to their destination one tile at a time
 // without colliding with other RouteWalkers
+//
+// Each RouteWalker has a speed between 0.2 and 1 tiles per tick. Every tick
+// it gains speed*STEP_PROGRESS progress, up to STEP_PROGRESS, and it can only
+// step when it has STEP_PROGRESS progress, which the step uses up. Slower
+// walkers so have steps of their Plans where they must wait, and their
+// intentions show them staying on their tile for those ticks, which lets
+// faster walkers plan around them.
+//
+// A RouteWalker made by NewFlowWalker follows a shared path.FlowField instead
+// of its own Route, so many walkers can be sent to one place cheaply. Its
+// route cursor is found afresh from the field each tick, so it follows the
+// field as walls change.
+//
+// A spawned RouteWalker doesn't find its route, or build its FlowField,
+// itself. It requests it as a job that runs between ticks, see
+// path.Request, and stands still until the result arrives on a later tick,
+// so many walkers spawning at once don't stall a tick.
+//
+// A wall operation touching the blocks of a RouteWalker's route makes it
+// stale, see path.RouteTracker. The walker then stops, and requests a new
+// route from its route cursor in the same way.
 
 package entity
 
 import (
+	"bytes"
+	"encoding/binary"
 	"jds/game"
 	"jds/game/layer"
 	"jds/game/world"
 	"jds/game/world/path"
-	"math/rand"
 )
 
 // Definitions
@@ -40,79 +62,332 @@ type Plan [PLAN_LENGTH]game.Direction
 // BITWIDTH must be greater than or equal to PLAN_LENGTH
 const BITWIDTH = 8
 
+// Progress needed to take a step, see RouteWalker.speed
+const STEP_PROGRESS = 1024
+
 type RouteWalker struct {
 	id          world.EntityId
 	w           *world.World
 	l           game.Location
 	sc          *layer.StackCursor
 	route       path.Route
+	track       path.RouteTracker
+	cost        *layer.Layer // for planning routes, may be nil
 	dest        game.Location
-	speed       float64
+	speed       float64 // tiles per tick
+	progress    int     // towards the next step, up to STEP_PROGRESS
 	intentions  *layer.Layer
 	routeCursor game.Location
 	routeStep   int
 	plan        Plan
-	planSet     bool // plan has been set in intention layer
+	planSet     bool      // plan has been set in intention layer
+	planTick    game.Tick // plan's intention bits start at this tick
 	color       game.Color
+	next        game.Tick // tick of the pending Act, 0 if none
+	goal        walkGoal  // nil to die at dest
+	target      *path.FlowTarget
+	flow        *path.FlowField // towards target, nil if following route
+	waiting     bool            // for the job finding the route, see request
+}
+
+// Decides where the walk of a RouteWalker ends, and what happens there
+type walkGoal interface {
+	// Returns true if the walk is over at l
+	Reached(l game.Location) bool
+	// The walk is over. Called instead of the RouteWalker dying.
+	Arrived(ta *world.ActionAccumulator)
+	// Walls now block the way to dest. Called instead of the RouteWalker
+	// dying.
+	Lost(ta *world.ActionAccumulator)
 }
 
 const (
 	entityIndex    = 0
 	wallIndex      = 1
 	intentionIndex = 2
+	doorIndex      = 3
 )
 
 func NewRouteWalker(l game.Location, dest game.Location, color game.Color) *RouteWalker {
 	return &RouteWalker{
 		l:     l,
 		dest:  dest,
-		speed: rand.Float64()*0.8 + 0.2,
 		color: color,
 	}
 }
 
+// Returns a RouteWalker that follows the FlowField towards target, and dies
+// when it reaches target
+func NewFlowWalker(l game.Location, target path.FlowTarget, color game.Color) *RouteWalker {
+	return &RouteWalker{
+		l:      l,
+		dest:   l,
+		target: &target,
+		color:  color,
+	}
+}
+
 func (t *RouteWalker) Location() game.Location {
 	return t.l
 }
 
+// Sets t's speed, in tiles per tick. Speeds above 1 are treated as 1. Must be
+// called before t is spawned, otherwise t gets a random speed between 0.2 and
+// 1.
+func (t *RouteWalker) SetSpeed(speed float64) {
+	t.speed = speed
+}
+
+func (t *RouteWalker) Speed() float64 {
+	return t.speed
+}
+
+// Returns the progress t gains each tick
+func (t *RouteWalker) stride() int {
+	s := int(t.speed*STEP_PROGRESS + 0.5)
+	if s < 1 {
+		return 1
+	}
+	return s
+}
+
+// Returns t's progress after gaining a tick's worth to 'progress'
+func (t *RouteWalker) gain(progress int) int {
+	if progress += t.stride(); progress > STEP_PROGRESS {
+		return STEP_PROGRESS
+	}
+	return progress
+}
+
+// Returns which steps of a Plan starting now t will be able to move in,
+// assuming it moves whenever it can
+func (t *RouteWalker) canMove() (can [PLAN_LENGTH]bool) {
+	p := t.progress
+	for step := range can {
+		p = t.gain(p)
+		if p == STEP_PROGRESS {
+			can[step] = true
+			p = 0
+		}
+	}
+	return
+}
+
 func (t *RouteWalker) Spawned(ta *world.ActionAccumulator, id world.EntityId, w *world.World, sc *layer.StackCursor) {
-	now := uint(w.Now())
+	t.init(id, w, sc)
+	if t.target != nil {
+		t.flow = path.Flows(w).Acquire(*t.target)
+	}
+	t.request(ta)
+}
+
+// Finds t's route to dest, or builds t.flow, in a job between ticks, then
+// starts walking
+func (t *RouteWalker) request(ta *world.ActionAccumulator) {
+	t.waiting = true
+	if t.flow != nil {
+		f, l := t.flow, t.l
+		ta.Submit(func() {
+			f.Distance(l)
+		}, func(ta *world.ActionAccumulator) {
+			t.begin(ta, nil)
+		}, t.l.BlockId)
+		return
+	}
+	path.Request(ta, t.w, t.l, t.dest, t.cost, t.l.BlockId, t.begin)
+}
+
+// Starts walking along route, or t.flow, once the job started by request is
+// done. If another walker is passing through, tries again next tick.
+func (t *RouteWalker) begin(ta *world.ActionAccumulator, route path.Route) {
+	var ok bool
+	if t.flow != nil {
+		ok = t.follow(ta)
+	} else {
+		ok = t.walkTo(ta, t.dest, route)
+	}
+	if ok {
+		t.waiting = false
+		return
+	}
+	ta.AddKeyed("walk", t.w.Now()+1, func(ta *world.ActionAccumulator) {
+		t.begin(ta, route)
+	}, t.l.BlockId)
+}
+
+// Stops t, and requests a new route to dest in a job, after walls or doors
+// have changed under its route. The new route starts at the route cursor,
+// unless t can no longer see it, or it is past a door in another room.
+func (t *RouteWalker) repair(ta *world.ActionAccumulator) {
+	t.next = 0
+	t.waiting = true
+	from, step := t.routeCursor, t.routeStep
+	for t.w.DoorIds.Get(from) != 0 && step < t.route.Len() {
+		// routes can't start in a doorway, start past it
+		from = from.JustStep(t.route.Direction(uint(step)))
+		step++
+	}
+	rid := t.w.RoomIds.Get(t.l)
+	if t.w.Walls.Get(from) != 0 || t.sc.ObstructedExcept(wallIndex, doorIndex, from) ||
+		(rid != 0 && t.w.RoomIds.Get(from) != rid) {
+		from = t.l
+	}
+	path.Request(ta, t.w, from, t.dest, t.cost, t.l.BlockId, func(ta *world.ActionAccumulator, route path.Route) {
+		t.resume(ta, from, route)
+	})
+}
+
+// Starts walking along route, which starts at 'from', once the job started
+// by repair is done. If another walker is passing through, tries again next
+// tick.
+func (t *RouteWalker) resume(ta *world.ActionAccumulator, from game.Location, route path.Route) {
+	if route.Len() == 0 && from != t.dest {
+		// dest can no longer be reached
+		t.waiting = false
+		if t.goal != nil {
+			t.goal.Lost(ta)
+		} else {
+			t.die(ta)
+		}
+		return
+	}
+	t.route = route
+	t.track = path.Track(t.w, from, route)
+	t.routeCursor = from
+	t.routeStep = 0
+	if t.start(ta, true) {
+		t.waiting = false
+		return
+	}
+	ta.AddKeyed("walk", t.w.Now()+1, func(ta *world.ActionAccumulator) {
+		t.resume(ta, from, route)
+	}, t.l.BlockId)
+}
+
+// Sets up t when it spawns into w
+func (t *RouteWalker) init(id world.EntityId, w *world.World, sc *layer.StackCursor) {
 	t.w = w
 	t.id = id
 	t.sc = sc
+	rng := w.EntityRand(id)
+	if t.speed == 0 {
+		t.speed = rng.Float64()*0.8 + 0.2
+	}
+	t.addLayers()
+}
+
+// Starts walking along route to dest. Returns false, and doesn't start, if
+// another walker intends to pass through t's location.
+func (t *RouteWalker) walkTo(ta *world.ActionAccumulator, dest game.Location, route path.Route) bool {
+	t.dest = dest
+	t.route = route
+	t.track = path.Track(t.w, t.l, route)
 	t.routeCursor = t.l
 	t.routeStep = 0
-	t.route = path.NewRoute(w, t.l, t.dest)
-	//fmt.Printf("spawned id:%d tick:%d loc:%v\n", t.id, now, t.l)
 	for t.routeStep < PLAN_LENGTH+1 && t.routeStep < t.route.Len()-1 {
 		t.routeCursor = t.routeCursor.JustStep(t.route.Direction(uint(t.routeStep)))
 		t.routeStep++
 	}
-	t.intentions = t.w.CustomLayer("RouteWalkerIntentions")
-	if t.sc.Add(t.intentions) != intentionIndex {
-		panic("unexpected layer index")
+	return t.start(ta, t.route.Len() > 0)
+}
+
+// Starts following t.flow. Returns false, and doesn't start, if another
+// walker intends to pass through t's location.
+func (t *RouteWalker) follow(ta *world.ActionAccumulator) bool {
+	t.route = nil
+	t.routeStep = 0
+	t.routeCursor = t.flowCursor()
+	return t.start(ta, t.flow.Distance(t.l) > 0)
+}
+
+// Returns the tile up to PLAN_LENGTH+1 steps from t along t.flow, stopping
+// early at the target or at a tile that can't be seen from t
+func (t *RouteWalker) flowCursor() game.Location {
+	rc := t.l
+	for i := 0; i <= PLAN_LENGTH; i++ {
+		d, ok := t.flow.Step(rc)
+		if !ok || d == game.NONE {
+			break
+		}
+		next := rc.JustStep(d)
+		if t.sc.ObstructedExcept(wallIndex, doorIndex, next) {
+			break
+		}
+		rc = next
 	}
+	return rc
+}
+
+// Claims t's location, and starts acting if 'moving'. Returns false if
+// another walker intends to pass through t's location.
+func (t *RouteWalker) start(ta *world.ActionAccumulator, moving bool) bool {
+	now := uint(t.w.Now())
 	for i := 0; i <= PLAN_LENGTH; i++ {
 		i := uint(i)
 		if t.sc.GetBit(intentionIndex, (now+i-1)%BITWIDTH) {
-			//	panic("tried to spawn in another walker's path")
-			t.die(ta)
-			return
+			return false
 		}
 	}
 	for i := range t.plan {
-		i := uint(i)
 		t.plan[i] = game.NONE
-		t.sc.SetBit(intentionIndex, (now+i-1)%BITWIDTH, true)
-		//fmt.Println("set", t.id, now+i-1, t.sc.Cursor())
 	}
-	t.planSet = true
-	t.sc.SetBit(intentionIndex, (now+PLAN_LENGTH-1)%BITWIDTH, true)
-	//fmt.Println("set", t.id, now+PLAN_LENGTH-1, t.sc.Cursor())
-	if t.route.Len() > 0 {
+	t.setIntentions(now - 1)
+	if moving {
 		//ta.Add(t.w.Now()+1, t, t.l.BlockId)
 		t.Act(ta)
 	}
+	return true
+}
+
+// Returns true if t has finished its walk at its current location
+func (t *RouteWalker) reached() bool {
+	if t.goal != nil {
+		return t.goal.Reached(t.l)
+	}
+	if t.flow != nil {
+		return t.flow.Distance(t.l) == 0
+	}
+	return t.l == t.dest
+}
+
+// Adds the layers used by RouteWalkers to t.sc
+func (t *RouteWalker) addLayers() {
+	t.intentions = t.w.CustomLayer("RouteWalkerIntentions")
+	if t.sc.Add(t.intentions) != intentionIndex ||
+		t.sc.Add(t.w.DoorIds) != doorIndex {
+		panic("unexpected layer index")
+	}
+}
+
+// Signal our intention to follow t.plan, starting at tick planTick
+func (t *RouteWalker) setIntentions(planTick uint) {
+	t.sc.Push()
+	for step, d := range t.plan {
+		step := uint(step)
+		//fmt.Println("set", t.sc.Cursor(), (planTick+step)%BITWIDTH)
+		if t.sc.GetBit(intentionIndex, (planTick+step)%BITWIDTH) {
+			panic("intention bit already set")
+		}
+		t.sc.SetBit(intentionIndex, (planTick+step)%BITWIDTH, true)
+		t.sc.Step(d)
+		if d != game.NONE && t.sc.GetBit(intentionIndex, (planTick+step)%BITWIDTH) {
+			panic("intention bit already set")
+		}
+		t.sc.SetBit(intentionIndex, (planTick+step)%BITWIDTH, true)
+	}
+	if t.sc.GetBit(intentionIndex, (planTick+PLAN_LENGTH)%BITWIDTH) {
+		panic("intention bit already set")
+	}
+	t.sc.SetBit(intentionIndex, (planTick+PLAN_LENGTH)%BITWIDTH, true)
+	t.sc.Pop()
+	t.planSet = true
+	t.planTick = game.Tick(planTick)
+}
+
+// Schedule t.Act for tick 'at'
+func (t *RouteWalker) schedule(ta *world.ActionAccumulator, at game.Tick) {
+	t.next = at
+	ta.AddKeyed("walk", at, t.Act, t.l.BlockId)
 }
 
 func (t *RouteWalker) Touched(other world.EntityId, d game.Direction) {
@@ -125,9 +400,34 @@ func (t *RouteWalker) die(ta *world.ActionAccumulator) {
 	ta.Kill(t.id)
 }
 
+// Withdraws t's intention to follow its plan, so that other walkers don't
+// wait for it, and stops using its FlowField
+func (t *RouteWalker) Despawned(ta *world.ActionAccumulator) {
+	if t.flow != nil {
+		t.flow.Release()
+		t.flow = nil
+	}
+	if !t.planSet {
+		return
+	}
+	planTick := uint(t.planTick)
+	t.sc.Push()
+	for step, d := range t.plan {
+		bit := (planTick + uint(step)) % BITWIDTH
+		t.sc.SetBit(intentionIndex, bit, false)
+		t.sc.Step(d)
+		t.sc.SetBit(intentionIndex, bit, false)
+	}
+	t.sc.SetBit(intentionIndex, (planTick+PLAN_LENGTH)%BITWIDTH, false)
+	t.sc.Pop()
+	t.planSet = false
+}
+
 func (t *RouteWalker) Act(ta *world.ActionAccumulator) {
 	var makeplan func(uint, *Plan, game.Location) (rcDist int, viable bool)
 	now := uint(t.w.Now())
+	rng := t.w.EntityRand(t.id)
+	canMove := t.canMove()
 
 	//fmt.Printf("*** RouteWalker Act id:%d tick:%d\n", t.id, now)
 	makeplan = func(step uint, plan *Plan, rc game.Location) (waits int, viable bool) {
@@ -139,10 +439,16 @@ func (t *RouteWalker) Act(ta *world.ActionAccumulator) {
 					waits++
 				}
 			}
-			viable = !t.sc.Obstructed(wallIndex, rc)
+			viable = !t.sc.ObstructedExcept(wallIndex, doorIndex, rc)
 			//fmt.Println(plan, waits, viable)
 			return
 		}
+		if !canMove[step] && !t.sc.GetBit(intentionIndex, (now+step+1)%BITWIDTH) {
+			// too slow to move during this step. unless another entity
+			// wants our tile, and we must dodge it, wait here.
+			plan[step] = game.NONE
+			return makeplan(step+1, plan, rc)
+		}
 		// consider possible moves from t.sc's current location. only consider staying
 		// still, moves that move closer to the route cursor, and moves that don't
 		// collide with other entities or walls.
@@ -154,12 +460,15 @@ func (t *RouteWalker) Act(ta *world.ActionAccumulator) {
 		//fmt.Println("observe none", rcDist)
 		//m.Observe(game.NONE, rcDist)
 		wallLocal := t.sc.Look(wallIndex)
+		doorLocal := t.sc.Look(doorIndex)
 		intentionLocal := t.sc.Look(intentionIndex)
+		entityLocal := t.sc.Look(entityIndex)
 		almostViable := [8]bool{}
 		for d, rl := range t.sc.Cursor().Neighborhood() {
 			d := game.Direction(d)
-			// is there a wall here?
-			if wallLocal[d] != 0 {
+			// is there a wall here? walls under doors can be walked through,
+			// if the door's state allows it, as in World.StepEntity
+			if wallLocal[d] != 0 && (doorLocal[d] == 0 || !t.w.Doors[world.DoorId(doorLocal[d])].Passable(d)) {
 				// yes -- not viable
 				continue
 			}
@@ -169,10 +478,16 @@ func (t *RouteWalker) Act(ta *world.ActionAccumulator) {
 				// yes -- not viable
 				continue
 			}
+			// is another entity there now? if it has a plan its intentions
+			// were checked above, if not it stays there for at least a tick,
+			// e.g. after a collision
+			if step == 0 && entityLocal[d] != 0 {
+				continue
+			}
 			t.sc.Push()
 			t.sc.Step(d)
 			pushedDirection = d // take this direction if we must dodge an entity trying to move to our tile
-			if t.sc.Obstructed(wallIndex, rc) {
+			if t.sc.ObstructedExcept(wallIndex, doorIndex, rc) {
 				t.sc.Pop()
 				continue
 			}
@@ -216,7 +531,7 @@ func (t *RouteWalker) Act(ta *world.ActionAccumulator) {
 					plan[step] = d
 					t.sc.Push()
 					t.sc.Step(d)
-					if t.sc.Obstructed(wallIndex, rc) {
+					if t.sc.ObstructedExcept(wallIndex, doorIndex, rc) {
 						t.sc.Pop()
 						continue
 					}
@@ -283,21 +598,33 @@ func (t *RouteWalker) Act(ta *world.ActionAccumulator) {
 		t.sc.Pop()
 		t.planSet = false
 		// step according to plan
+		t.progress = t.gain(t.progress)
 		t.l, tookStep = t.w.StepEntity(t.id, t, t.sc, t.plan[0])
+		if tookStep && t.plan[0] != game.NONE {
+			// progress may become negative after dodging another entity
+			t.progress -= STEP_PROGRESS
+		}
 		if !tookStep {
-			ta.Add(game.Tick(now)+1+game.Tick(rand.Intn(3)), t.Act, t.l.BlockId)
+			t.schedule(ta, game.Tick(now)+1+game.Tick(rng.Intn(3)))
 			return
 		}
 	}
 	// advance route cursor
-	for i := 0; i < 2; i++ {
-		if t.routeStep < t.route.Len() {
-			newrc := t.routeCursor.JustStep(t.route.Direction(uint(t.routeStep)))
-			if !t.sc.Obstructed(wallIndex, newrc) {
-				t.routeCursor = newrc
-				t.routeStep++
-			} else {
-				break
+	if t.flow != nil {
+		t.routeCursor = t.flowCursor()
+	} else if t.track.Stale(t.w) {
+		t.repair(ta)
+		return
+	} else {
+		for i := 0; i < 2; i++ {
+			if t.routeStep < t.route.Len() {
+				newrc := t.routeCursor.JustStep(t.route.Direction(uint(t.routeStep)))
+				if !t.sc.ObstructedExcept(wallIndex, doorIndex, newrc) {
+					t.routeCursor = newrc
+					t.routeStep++
+				} else {
+					break
+				}
 			}
 		}
 	}
@@ -306,7 +633,7 @@ func (t *RouteWalker) Act(ta *world.ActionAccumulator) {
 	if !viable {
 		// TODO there is, or will be, some forced collision. what should be done?
 		//fmt.Println("no viable path!")
-		ta.Add(game.Tick(now)+1, t.Act, t.l.BlockId)
+		t.schedule(ta, game.Tick(now)+1)
 		return
 	}
 	// TODO remove sanity check
@@ -316,7 +643,7 @@ func (t *RouteWalker) Act(ta *world.ActionAccumulator) {
 		step := uint(step)
 		if t.sc.GetBit(intentionIndex, (now+step)%BITWIDTH) {
 			//fmt.Println(step, t.plan)
-			ta.Add(game.Tick(now)+1+game.Tick(rand.Intn(3)), t.Act, t.l.BlockId)
+			t.schedule(ta, game.Tick(now)+1+game.Tick(rng.Intn(3)))
 			t.sc.Pop()
 			return
 			//panic("makeplan returned path with collision")
@@ -324,7 +651,7 @@ func (t *RouteWalker) Act(ta *world.ActionAccumulator) {
 		t.sc.Step(d)
 		if t.sc.GetBit(intentionIndex, (now+step)%BITWIDTH) {
 			//fmt.Println(step, t.plan, t.dest, t.sc.Cursor())
-			ta.Add(game.Tick(now)+1+game.Tick(rand.Intn(3)), t.Act, t.l.BlockId)
+			t.schedule(ta, game.Tick(now)+1+game.Tick(rng.Intn(3)))
 			t.sc.Pop()
 			return
 			//panic("makeplan returned path with collision")
@@ -332,7 +659,7 @@ func (t *RouteWalker) Act(ta *world.ActionAccumulator) {
 	}
 	if t.sc.GetBit(intentionIndex, (now+PLAN_LENGTH)%BITWIDTH) {
 		//fmt.Println(PLAN_LENGTH, t.plan, t.dest, t.sc.Cursor())
-		ta.Add(game.Tick(now)+1+game.Tick(rand.Intn(3)), t.Act, t.l.BlockId)
+		t.schedule(ta, game.Tick(now)+1+game.Tick(rng.Intn(3)))
 		t.sc.Pop()
 		return
 		//panic("makeplan returned path with collision")
@@ -353,39 +680,20 @@ func (t *RouteWalker) Act(ta *world.ActionAccumulator) {
 	//
 	// intention layer flags are the sum of above
 	//   1 3 6 12 8
-	if t.l != t.dest {
+	if !t.reached() {
 		// haven't reached destination yet
-		t.sc.Push()
-		for step, d := range t.plan {
-			step := uint(step)
-			//fmt.Println("set", t.sc.Cursor(), (now+step)%BITWIDTH)
-			if t.sc.GetBit(intentionIndex, (now+step)%BITWIDTH) {
-				panic("intention bit already set")
-			}
-			t.sc.SetBit(intentionIndex, (now+step)%BITWIDTH, true)
-			//fmt.Println("set", t.id, now+step, t.sc.Cursor())
-			t.sc.Step(d)
-			if d != game.NONE && t.sc.GetBit(intentionIndex, (now+step)%BITWIDTH) {
-				//fmt.Println(step)
-				panic("intention bit already set")
-			}
-			t.sc.SetBit(intentionIndex, (now+step)%BITWIDTH, true)
-			//fmt.Println("set", t.id, now+step, t.sc.Cursor())
-		}
-		if t.sc.GetBit(intentionIndex, (now+PLAN_LENGTH)%BITWIDTH) {
-			panic("intention bit already set")
-		}
-		t.sc.SetBit(intentionIndex, (now+PLAN_LENGTH)%BITWIDTH, true)
-		//fmt.Println("set", t.id, now+PLAN_LENGTH, t.sc.Cursor())
-		t.sc.Pop()
-		t.planSet = true
+		t.setIntentions(now)
 		//fmt.Println("local intentions", t.sc.Get(intentionIndex))
 		//fmt.Println("nearby intentions", t.sc.Look(intentionIndex))
 		// TODO remove sanity checks
 		if t.l != t.sc.Cursor() || !t.sc.GetBit(intentionIndex, (now)%BITWIDTH) {
 			panic("asdf")
 		}
-		ta.Add(game.Tick(now+1), t.Act, t.l.BlockId)
+		t.schedule(ta, game.Tick(now+1))
+	} else if t.goal != nil {
+		// reached destination
+		t.next = 0
+		t.goal.Arrived(ta)
 	} else {
 		// reached destination
 		t.die(ta)
@@ -395,3 +703,148 @@ func (t *RouteWalker) Act(ta *world.ActionAccumulator) {
 func (t *RouteWalker) Color() game.Color {
 	return t.color
 }
+
+// Encoded RouteWalker, followed by RouteLen routeSegmentRecords, its int64
+// progress, a flowTargetRecord and whether it is waiting for its route.
+// Snapshots written before RouteWalkers had progress end after the
+// routeSegmentRecords, those written before they could follow FlowFields end
+// after the progress, and those written before they requested routes end
+// after the flowTargetRecord.
+type routeWalkerRecord struct {
+	L           locationRecord
+	Dest        locationRecord
+	RouteCursor locationRecord
+	Speed       float64
+	Color       game.Color
+	RouteStep   int64
+	Plan        Plan
+	PlanSet     bool
+	PlanTick    int64
+	Next        int64
+	RouteLen    uint32
+}
+
+type routeSegmentRecord struct {
+	Length uint32
+	D      game.Direction
+}
+
+type flowTargetRecord struct {
+	Follow bool // false if the RouteWalker follows its route
+	Kind   path.FlowTargetKind
+	L      locationRecord
+	Door   int64
+	Room   int64
+}
+
+func (t *RouteWalker) EntityType() string {
+	return "entity.RouteWalker"
+}
+
+func (t *RouteWalker) Marshal() ([]byte, error) {
+	segs := make([]routeSegmentRecord, len(t.route))
+	for i, rs := range t.route {
+		segs[i] = routeSegmentRecord{uint32(rs.Length), rs.D}
+	}
+	var flow flowTargetRecord
+	if t.target != nil {
+		flow = flowTargetRecord{
+			Follow: true,
+			Kind:   t.target.Kind,
+			L:      newLocationRecord(t.target.L),
+			Door:   int64(t.target.Door),
+			Room:   int64(t.target.Room),
+		}
+	}
+	return marshal(routeWalkerRecord{
+		L:           newLocationRecord(t.l),
+		Dest:        newLocationRecord(t.dest),
+		RouteCursor: newLocationRecord(t.routeCursor),
+		Speed:       t.speed,
+		Color:       t.color,
+		RouteStep:   int64(t.routeStep),
+		Plan:        t.plan,
+		PlanSet:     t.planSet,
+		PlanTick:    int64(t.planTick),
+		Next:        int64(t.next),
+		RouteLen:    uint32(len(segs)),
+	}, segs, int64(t.progress), flow, t.waiting)
+}
+
+func (t *RouteWalker) Unmarshal(data []byte) error {
+	var rec routeWalkerRecord
+	r := bytes.NewReader(data)
+	if err := binary.Read(r, binary.LittleEndian, &rec); err != nil {
+		return err
+	}
+	segs := make([]routeSegmentRecord, rec.RouteLen)
+	if err := binary.Read(r, binary.LittleEndian, segs); err != nil {
+		return err
+	}
+	var progress int64
+	if r.Len() > 0 {
+		if err := binary.Read(r, binary.LittleEndian, &progress); err != nil {
+			return err
+		}
+	}
+	t.progress = int(progress)
+	var flow flowTargetRecord
+	if r.Len() > 0 {
+		if err := binary.Read(r, binary.LittleEndian, &flow); err != nil {
+			return err
+		}
+	}
+	t.waiting = false
+	if r.Len() > 0 {
+		if err := binary.Read(r, binary.LittleEndian, &t.waiting); err != nil {
+			return err
+		}
+	}
+	t.target = nil
+	if flow.Follow {
+		t.target = &path.FlowTarget{
+			Kind: flow.Kind,
+			L:    flow.L.Location(),
+			Door: world.DoorId(flow.Door),
+			Room: world.RoomId(flow.Room),
+		}
+	}
+	t.route = make(path.Route, len(segs))
+	for i, rs := range segs {
+		t.route[i] = path.RouteSegment{Length: uint(rs.Length), D: rs.D}
+	}
+	t.l = rec.L.Location()
+	t.dest = rec.Dest.Location()
+	t.routeCursor = rec.RouteCursor.Location()
+	t.speed = rec.Speed
+	t.color = rec.Color
+	t.routeStep = int(rec.RouteStep)
+	t.plan = rec.Plan
+	t.planSet = rec.PlanSet
+	t.planTick = game.Tick(rec.PlanTick)
+	t.next = game.Tick(rec.Next)
+	return nil
+}
+
+// Restores t's intentions and pending Act, and requests its route again if
+// it was waiting for it
+func (t *RouteWalker) Restored(ta *world.ActionAccumulator, id world.EntityId, w *world.World, sc *layer.StackCursor) {
+	t.w = w
+	t.id = id
+	t.sc = sc
+	t.addLayers()
+	if t.target != nil {
+		t.flow = path.Flows(w).Acquire(*t.target)
+	} else {
+		t.track = path.Track(w, t.routeCursor, t.route.Skip(t.routeStep))
+	}
+	if t.planSet {
+		t.setIntentions(uint(t.planTick))
+	}
+	if t.next != 0 {
+		t.schedule(ta, t.next)
+	}
+	if t.waiting {
+		t.request(ta)
+	}
+}
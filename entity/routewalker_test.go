@@ -0,0 +1,213 @@
+package entity
+
+import (
+	"jds/game"
+	"jds/game/world"
+	"jds/game/world/path"
+	"testing"
+)
+
+func TestRouteWalkerKilled(t *testing.T) {
+	w := world.NewWorld(0)
+	defer w.Discard()
+	l := game.Location{}
+	w.DrawBox(l, l.JustOffset(40, 10))
+	eid := w.Spawn(NewRouteWalker(l.JustOffset(2, 5), l.JustOffset(38, 5), game.Color{}))
+	for i := 0; i < 5; i++ {
+		w.Think()
+	}
+	if w.Entities[eid] == nil {
+		t.Fatal("walker arrived too soon")
+	}
+	// The intentions of a killed walker are withdrawn
+	w.Kill(eid)
+	intentions := w.CustomLayer("RouteWalkerIntentions")
+	for x := 0; x <= 40; x++ {
+		for y := 0; y <= 10; y++ {
+			if intentions.Get(l.JustOffset(x, y)) != 0 {
+				t.Fatal("intention left at", l.JustOffset(x, y))
+			}
+		}
+	}
+}
+
+func TestRouteWalkerSpeed(t *testing.T) {
+	w := world.NewWorld(0)
+	defer w.Discard()
+	l := game.Location{}
+	w.DrawBox(l, l.JustOffset(60, 6))
+	// The fast walker starts behind the slow one, and overtakes it
+	slow := NewRouteWalker(l.JustOffset(6, 3), l.JustOffset(55, 3), game.Color{})
+	slow.SetSpeed(0.25)
+	fast := NewRouteWalker(l.JustOffset(2, 3), l.JustOffset(56, 3), game.Color{})
+	fast.SetSpeed(1)
+	sid, fid := w.Spawn(slow), w.Spawn(fast)
+	var slowTicks, fastTicks int
+	for i := 1; i <= 400 && len(w.Entities) > 0; i++ {
+		w.Think()
+		if slowTicks == 0 && w.Entities[sid] == nil {
+			slowTicks = i
+		}
+		if fastTicks == 0 && w.Entities[fid] == nil {
+			fastTicks = i
+		}
+	}
+	if slowTicks == 0 || fastTicks == 0 {
+		t.Fatal("walkers didn't arrive")
+	}
+	// 49 tiles at 1/4 tile per tick, and 54 tiles at 1 tile per tick
+	if slowTicks < 49*4 || slowTicks > 49*4+50 || fastTicks > 54+20 {
+		t.Error("walkers arrived at wrong ticks:", slowTicks, fastTicks)
+	}
+}
+
+func TestFlowWalkers(t *testing.T) {
+	w := world.NewWorld(0)
+	defer w.Discard()
+	l := game.Location{}
+	w.DrawBox(l, l.JustOffset(20, 20))
+	w.DrawBox(l.JustOffset(20, 0), l.JustOffset(40, 20))
+	if w.NewDoor(l.JustOffset(19, 8), world.VERT, nil) == nil {
+		t.Fatal("couldn't place door")
+	}
+	// Everyone leaves the left room through the door
+	right := world.RoomId(w.RoomIds.Get(l.JustOffset(30, 10)))
+	target := path.ToRoom(right)
+	r := world.NewRecorder(100000)
+	w.SetRecorder(r)
+	for x := 2; x < 18; x += 3 {
+		for y := 2; y < 18; y += 3 {
+			w.Spawn(NewFlowWalker(l.JustOffset(x, y), target, game.Color{}))
+		}
+	}
+	w.Think()
+	if path.Flows(w).Len() != 1 {
+		t.Fatal("walkers don't share a FlowField")
+	}
+	for i := 0; i < 500 && len(w.Entities) > 0; i++ {
+		w.Think()
+	}
+	if len(w.Entities) > 0 {
+		t.Fatal(len(w.Entities), "walkers didn't arrive")
+	}
+	for _, e := range r.Events() {
+		if e.Kind == world.MOVE_DEATH && world.RoomId(w.RoomIds.Get(e.L)) != right {
+			t.Error("walker died outside the target at", e.L)
+		}
+	}
+	if path.Flows(w).Len() != 0 {
+		t.Error("FlowField wasn't released")
+	}
+}
+
+func TestRouteRequests(t *testing.T) {
+	w := world.NewWorld(0)
+	defer w.Discard()
+	l := game.Location{}
+	w.DrawBox(l, l.JustOffset(200, 140))
+	// 1000 walkers spawn at once, and each walks to the right of the room
+	var walkers []*RouteWalker
+	for x := 2; x < 68; x += 3 {
+		for y := 2; y < 138 && len(walkers) < 1000; y += 3 {
+			rw := NewRouteWalker(l.JustOffset(x, y), l.JustOffset(x+130, y), game.Color{})
+			w.Spawn(rw)
+			walkers = append(walkers, rw)
+		}
+	}
+	if len(walkers) != 1000 {
+		t.Fatal("wrong number of walkers:", len(walkers))
+	}
+	// Their routes are found a few at a time between ticks
+	w.Think()
+	if w.ThinkStats.Jobs == 0 || w.ThinkStats.Jobs+w.PendingJobs() != 1000 || w.ThinkStats.JobQueue != w.PendingJobs() {
+		t.Fatal("wrong jobs after spawning:", w.ThinkStats.Jobs, w.PendingJobs())
+	}
+	for i := 0; i < 1000 && w.PendingJobs() > 0; i++ {
+		w.Think()
+	}
+	if w.PendingJobs() != 0 || w.ThinkStats.Jobs != 1000 {
+		t.Fatal("jobs didn't drain:", w.ThinkStats.Jobs, w.PendingJobs())
+	}
+	for i := 0; i < 2000 && len(w.Entities) > 0; i++ {
+		w.Think()
+	}
+	if len(w.Entities) > 0 {
+		t.Error(len(w.Entities), "walkers didn't arrive")
+	}
+}
+
+func TestRouteRepair(t *testing.T) {
+	w := world.NewWorld(0)
+	defer w.Discard()
+	l := game.Location{}
+	w.DrawBox(l, l.JustOffset(120, 60))
+	for x := 2; x < 32; x += 3 {
+		for y := 2; y < 58; y += 3 {
+			w.Spawn(NewRouteWalker(l.JustOffset(x, y), l.JustOffset(x+85, y), game.Color{}))
+		}
+	}
+	n := len(w.Entities)
+	for i := 0; i < 20; i++ {
+		w.Think()
+	}
+	if len(w.Entities) != n {
+		t.Fatal("walkers arrived too soon")
+	}
+	// A wall across the room, with a gap at the bottom, blocks every route
+	w.DrawLine(l.JustOffset(60, 0), l.JustOffset(60, 50))
+	r := world.NewRecorder(100000)
+	w.SetRecorder(r)
+	for i := 0; i < 2000 && len(w.Entities) > 0; i++ {
+		w.Think()
+	}
+	if len(w.Entities) > 0 {
+		t.Error(len(w.Entities), "walkers stranded by the new wall")
+	}
+	for _, e := range r.Events() {
+		if dx, _ := l.Distance(e.L); e.Kind == world.MOVE_DEATH && dx < 60 {
+			t.Error("walker died before passing the wall at", e.L)
+		}
+	}
+	// A walker walled off from its destination gives up
+	eid := w.Spawn(NewRouteWalker(l.JustOffset(2, 30), l.JustOffset(110, 30), game.Color{}))
+	for i := 0; i < 10; i++ {
+		w.Think()
+	}
+	w.DrawLine(l.JustOffset(60, 50), l.JustOffset(60, 60))
+	for i := 0; i < 10; i++ {
+		w.Think()
+	}
+	if w.Entities[eid] != nil {
+		t.Error("walled off walker didn't give up")
+	}
+}
+
+func TestRouteWalkerLockedDoor(t *testing.T) {
+	w, rooms := mall(t)
+	defer w.Discard()
+	eid := w.Spawn(NewRouteWalker(rooms[0], rooms[2], game.Color{}))
+	r := world.NewRecorder(10000)
+	w.SetRecorder(r)
+	for i := 0; i < 4; i++ {
+		w.Think()
+	}
+	// Once the first door is locked, the walker can't leave the entrance
+	did := world.DoorId(w.DoorIds.Get(game.Location{}.JustOffset(19, 9)))
+	if !w.SetDoorState(did, world.DOOR_LOCKED) {
+		t.Fatal("door not found")
+	}
+	for i := 0; i < 200 && w.Entities[eid] != nil; i++ {
+		w.Think()
+	}
+	if w.Entities[eid] != nil {
+		t.Fatal("walker behind locked door didn't give up")
+	}
+	for _, e := range r.Events() {
+		if e.Kind == world.MOVE_HIT_WALL {
+			t.Error("walker walked into locked door at", e.L)
+		}
+		if rid := w.RoomIds.Get(e.L); rid != 0 && rid != w.RoomIds.Get(rooms[0]) {
+			t.Error("walker left the entrance at", e.L)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package layer
+
+import (
+	"encoding/binary"
+	"io"
+	"jds/game"
+	"sort"
+)
+
+// Encoded header of a single block
+type blockHeader struct {
+	X, Y int64
+}
+
+// Returns the BlockIds of the blocks in l that have at least one non-zero
+// tile, sorted by Y then X
+func (l *Layer) NonZeroBlocks() (bids []game.BlockId) {
+	for bid, b := range l.bs {
+		if b.tiles != ([game.BLOCK_SIZE][game.BLOCK_SIZE]game.TileId{}) {
+			bids = append(bids, bid)
+		}
+	}
+	sort.Slice(bids, func(i, j int) bool {
+		if bids[i].Y != bids[j].Y {
+			return bids[i].Y < bids[j].Y
+		}
+		return bids[i].X < bids[j].X
+	})
+	return
+}
+
+// Writes the non-zero blocks of l to w. The output is the same for Layers
+// with the same contents.
+func (l *Layer) Encode(w io.Writer) error {
+	bids := l.NonZeroBlocks()
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(bids))); err != nil {
+		return err
+	}
+	for _, bid := range bids {
+		h := blockHeader{int64(bid.X), int64(bid.Y)}
+		if err := binary.Write(w, binary.LittleEndian, h); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, &l.bs[bid].tiles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads blocks written by Encode from r into l, replacing any existing
+// blocks with the same BlockIds
+func (l *Layer) Decode(r io.Reader) error {
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return err
+	}
+	for i := uint32(0); i < count; i++ {
+		var h blockHeader
+		if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+			return err
+		}
+		b := l.fetch(game.BlockId{X: int(h.X), Y: int(h.Y)})
+		if err := binary.Read(r, binary.LittleEndian, &b.tiles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns true if l and m have the same value at every Location
+func (l *Layer) Equal(m *Layer) bool {
+	lb, mb := l.NonZeroBlocks(), m.NonZeroBlocks()
+	if len(lb) != len(mb) {
+		return false
+	}
+	for i := range lb {
+		if lb[i] != mb[i] || l.bs[lb[i]].tiles != m.bs[mb[i]].tiles {
+			return false
+		}
+	}
+	return true
+}
+
+// Mixes the tiles of block bid into h, and returns the result. Blocks that
+// don't exist are mixed in as all zero.
+func (l *Layer) HashBlock(bid game.BlockId, h uint64) uint64 {
+	const prime = 1099511628211 // 64 bit FNV prime
+	b := l.bs[bid]
+	if b == nil {
+		b = &zeroBlock
+	}
+	for y := range b.tiles {
+		for _, t := range b.tiles[y] {
+			h ^= uint64(uint32(t))
+			h *= prime
+		}
+	}
+	return h
+}
+
+var zeroBlock layerBlock
@@ -0,0 +1,42 @@
+package layer
+
+import (
+	"bytes"
+	"jds/game"
+	"math/rand"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	l := NewLayer()
+	defer l.Discard()
+	cursor := game.Location{}
+	for i := 0; i < 10000; i++ {
+		l.Set(cursor, game.TileId(rand.Intn(5)))
+		cursor = cursor.JustStep(game.Direction(rand.Intn(8)))
+	}
+	var buf bytes.Buffer
+	if err := l.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	m := NewLayer()
+	defer m.Discard()
+	if err := m.Decode(bytes.NewReader(encoded)); err != nil {
+		t.Fatal(err)
+	}
+	if !l.Equal(m) || !m.Equal(l) {
+		t.Error("decoded layer differs")
+	}
+	m.FsckNeighborPointers()
+	// encoding is deterministic
+	buf.Reset()
+	m.Encode(&buf)
+	if !bytes.Equal(buf.Bytes(), encoded) {
+		t.Error("re-encoded layer differs")
+	}
+	m.Set(cursor.JustOffset(1000, 1000), 1)
+	if l.Equal(m) {
+		t.Error("layers should differ")
+	}
+}
@@ -237,6 +237,12 @@ func (l *Layer) InBlockstore(bid game.BlockId) bool {
 	return ok
 }
 
+// Returns true if the Layer has no non-zero values. May return false if
+// every value set has since been set back to 0.
+func (l *Layer) Empty() bool {
+	return len(l.bs) == 0
+}
+
 // Returns a layerBlock for bid, allocating and initializing if needed
 func (l *Layer) fetch(bid game.BlockId) (b *layerBlock) {
 	l.m.Lock()
@@ -329,6 +335,7 @@ func (l *Layer) CollectRowMask(rm *game.RowMask) (values []game.TileId, distance
 }
 
 func (l *Layer) SetRowMask(rm *game.RowMask, v game.TileId, m game.ModMap) {
+	m.AddRowMask(rm)
 	width := rm.Width()
 	b := l.fetch(rm.Left.BlockId)
 	cursor := rm.Left
@@ -341,7 +348,6 @@ func (l *Layer) SetRowMask(rm *game.RowMask, v game.TileId, m game.ModMap) {
 				i++
 				if cursor.X == 0 {
 					cursor.BlockId.X++
-					m.AddBlock(cursor.BlockId)
 					b = b.N[game.RIGHT]
 					if b == nil {
 						b = l.fetch(cursor.BlockId)
@@ -125,6 +125,21 @@ func (sc *StackCursor) Obstructed(l LayerIndex, a game.Location) bool {
 	return false
 }
 
+// Like Obstructed, but non-zero values in layer l are ignored at tiles where
+// layer except is also non-zero.
+func (sc *StackCursor) ObstructedExcept(l, except LayerIndex, a game.Location) bool {
+	sc.Push()
+	for sc.c.MaxDistance(a) > 0 {
+		if sc.Get(l) != 0 && sc.Get(except) == 0 {
+			sc.Pop()
+			return true
+		}
+		sc.Step(sc.c.Towards(a))
+	}
+	sc.Pop()
+	return false
+}
+
 // Push current cursor location onto location stack
 func (sc *StackCursor) Push() {
 	sc.cStack = append(sc.cStack, sc.c)
@@ -33,6 +33,27 @@ func TestRowMask(t *testing.T) {
 	}
 }
 
+func TestModMapAddRowMask(t *testing.T) {
+	rm := NewRowMask(100)
+	rm.Left = Location{}.JustOffset(-40, 0)
+	for i := 0; i < 100; i++ {
+		// paint [5,50) and [90,100)
+		rm.Append((i >= 5 && i < 50) || i >= 90)
+	}
+	m := NewModMap()
+	m.AddRowMask(rm)
+	// painted tiles span X from -35 to 9 and 50 to 59
+	want := ModMap{{X: -2}: {}, {X: -1}: {}, {X: 0}: {}, {X: 1}: {}}
+	if len(m) != len(want) {
+		t.Error("got blocks", m)
+	}
+	for bid := range want {
+		if _, ok := m[bid]; !ok {
+			t.Error("missing block", bid)
+		}
+	}
+}
+
 func TestRowMaskDist(t *testing.T) {
 	N := 1000
 	rm := NewRowMask(N)
@@ -20,14 +20,10 @@ func (m ModMap) AddRowMask(rm *RowMask) {
 	for i := 0; i < rm.Width(); {
 		paint, skip := rm.Mask(i)
 		if paint {
-			m.AddBlock(cursor.BlockId)
-		}
-		for skip >= BLOCK_SIZE {
-			skip -= BLOCK_SIZE
-			i += BLOCK_SIZE
-			cursor.BlockId.X++
-			if paint {
-				m.AddBlock(cursor.BlockId)
+			// every block from cursor to the end of the run
+			end := cursor.JustOffset(skip-1, 0)
+			for bid := cursor.BlockId; bid.X <= end.BlockId.X; bid.X++ {
+				m.AddBlock(bid)
 			}
 		}
 		i += skip
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"jds/game"
 	"sort"
+	"sync/atomic"
 )
 
 // Collects new ScheduledActions and sorts them by tick
@@ -19,11 +20,19 @@ type ActionAccumulator struct {
 		Spawns []Entity
 		Deaths []EntityId
 	}
-	closed bool
+	// Jobs submitted, see Submit
+	jobs []job
+	// Entity whose Action or event is running, see Add
+	owner EntityId
+	// World processing the ActionAccumulator, for AddKeyed
+	w *World
+	// Set by Close. Think reads it while the worker filling the
+	// ActionAccumulator may still be running, so it is accessed atomically.
+	closed int32
 }
 
 func (aa *ActionAccumulator) AddAction(th ScheduledAction) {
-	if aa.closed {
+	if aa.IsClosed() {
 		panic("add to closed ActionAccumulator")
 	}
 	if th.At == aa.nextTick {
@@ -37,33 +46,154 @@ func (aa *ActionAccumulator) AddAction(th ScheduledAction) {
 }
 
 func (aa *ActionAccumulator) Close() {
-	if aa.closed {
+	if !atomic.CompareAndSwapInt32(&aa.closed, 0, 1) {
 		panic("closed already closed channel")
 	}
-	aa.closed = true
 }
 
 func (aa *ActionAccumulator) IsClosed() bool {
-	return aa.closed
+	return atomic.LoadInt32(&aa.closed) != 0
 }
 
+// Refers to an Action added by ActionAccumulator.AddHandle or AddKeyed, so
+// that it can be cancelled or moved to another tick
+type ActionHandle struct {
+	// Incremented each time the Action is cancelled or rescheduled. Only the
+	// ScheduledAction with the current generation runs.
+	gen     int32
+	at      game.Tick
+	do      Action
+	blockId game.BlockId
+	entity  EntityId
+	// Set for keyed Actions, see AddKeyed
+	w   *World
+	key actionKey
+}
+
+type actionKey struct {
+	Entity EntityId
+	Name   string
+}
+
+// Schedules 'do' for tick 'at' in block bid. The Action belongs to the same
+// Entity as the Action or event adding it, and is cancelled if that Entity
+// dies first.
 func (aa *ActionAccumulator) Add(at game.Tick, do Action, bid game.BlockId) {
 	aa.AddAction(ScheduledAction{
 		At:      at,
 		Do:      do,
 		BlockId: bid,
+		Entity:  aa.owner,
+	})
+}
+
+// Like Add, but returns a handle to the Action
+func (aa *ActionAccumulator) AddHandle(at game.Tick, do Action, bid game.BlockId) *ActionHandle {
+	h := &ActionHandle{
+		at:      at,
+		do:      do,
+		blockId: bid,
+		entity:  aa.owner,
+	}
+	aa.schedule(h)
+	return h
+}
+
+// Adds the ScheduledAction for the current generation of h
+func (aa *ActionAccumulator) schedule(h *ActionHandle) {
+	aa.AddAction(ScheduledAction{
+		At:      h.at,
+		Do:      h.do,
+		BlockId: h.blockId,
+		Entity:  h.entity,
+		handle:  h,
+		gen:     atomic.LoadInt32(&h.gen),
 	})
 }
 
+// Cancels the Action of h, if it hasn't run yet
+func (h *ActionHandle) Cancel() {
+	atomic.AddInt32(&h.gen, 1)
+	if h.w != nil {
+		h.w.clearKeyed(h)
+	}
+}
+
+// Moves the Action of h to tick 'at'. It runs then, even if it has already
+// run or has been cancelled.
+func (aa *ActionAccumulator) Reschedule(h *ActionHandle, at game.Tick) {
+	atomic.AddInt32(&h.gen, 1)
+	h.at = at
+	if h.w != nil {
+		h.w.setKeyed(h)
+	}
+	aa.schedule(h)
+}
+
+// Returns true if ScheduledAction a hasn't been cancelled or rescheduled
+func (a *ScheduledAction) live() bool {
+	return a.handle == nil || atomic.LoadInt32(&a.handle.gen) == a.gen
+}
+
+// Like AddHandle, but the Action is keyed by name and the Entity it belongs to.
+// A pending Action of the Entity with the same name is cancelled, so there is
+// at most one.
+func (aa *ActionAccumulator) AddKeyed(name string, at game.Tick, do Action, bid game.BlockId) *ActionHandle {
+	if aa.w == nil {
+		panic("keyed Action added to ActionAccumulator without a World")
+	}
+	h := &ActionHandle{
+		at:      at,
+		do:      do,
+		blockId: bid,
+		entity:  aa.owner,
+		w:       aa.w,
+		key:     actionKey{aa.owner, name},
+	}
+	aa.w.setKeyed(h)
+	aa.schedule(h)
+	return h
+}
+
+// Makes h the pending Action for its key, cancelling the previous one
+func (w *World) setKeyed(h *ActionHandle) {
+	w.keyedMutex.Lock()
+	if old := w.keyed[h.key]; old != nil && old != h {
+		atomic.AddInt32(&old.gen, 1)
+	}
+	w.keyed[h.key] = h
+	w.keyedMutex.Unlock()
+}
+
+// Forgets h, if it is the pending Action for its key
+func (w *World) clearKeyed(h *ActionHandle) {
+	w.keyedMutex.Lock()
+	if w.keyed[h.key] == h {
+		delete(w.keyed, h.key)
+	}
+	w.keyedMutex.Unlock()
+}
+
+// Allocates an ActionAccumulator for w with nextTick t
+func (w *World) allocateAA(t game.Tick) *ActionAccumulator {
+	aa := AllocateAA(t)
+	aa.w = w
+	return aa
+}
+
 func (aa *ActionAccumulator) Spawn(e Entity) {
-	if aa.closed {
+	if aa.IsClosed() {
 		panic("add to closed ActionAccumulator")
 	}
 	aa.E.Spawns = append(aa.E.Spawns, e)
 }
 
+// Kills Entity e at the end of the current tick, once every Action of the
+// tick has run. Kills are done in the order their ActionAccumulators are
+// processed, after the spawns of the same ActionAccumulator. Killing an
+// Entity that is already dead does nothing. See World.Kill.
 func (aa *ActionAccumulator) Kill(e EntityId) {
-	if aa.closed {
+	if aa.IsClosed() {
 		panic("add to closed ActionAccumulator")
 	}
 	aa.E.Deaths = append(aa.E.Deaths, e)
@@ -85,7 +215,10 @@ func AllocateAA(t game.Tick) (aa *ActionAccumulator) {
 		aa.NextTick = aa.NextTick[:0]
 		aa.LaterTicks = aa.LaterTicks[:0]
 		aa.E.Deaths = aa.E.Deaths[:0]
-		aa.closed = false
+		aa.jobs = aa.jobs[:0]
+		aa.owner = ENTITYID_INVALID
+		aa.w = nil
+		aa.closed = 0
 	} else {
 		aa = new(ActionAccumulator)
 	}
@@ -102,5 +235,9 @@ func ReleaseAA(aa *ActionAccumulator) {
 		aa.E.Spawns[i] = nil
 	}
 	aa.E.Spawns = aa.E.Spawns[:0]
+	for i := range aa.jobs {
+		aa.jobs[i] = job{}
+	}
+	aa.jobs = aa.jobs[:0]
 	aaPool = append(aaPool, aa)
 }
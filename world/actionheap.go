@@ -11,6 +11,12 @@ type ScheduledAction struct {
 	At      game.Tick
 	Do      Action
 	BlockId game.BlockId
+	// The Entity the Action belongs to, see ActionAccumulator.Add
+	Entity EntityId
+	// The Action only runs if handle's generation is still gen, see
+	// ActionHandle
+	handle *ActionHandle
+	gen    int32
 }
 
 type actionHeapInner struct {
@@ -15,12 +15,44 @@ const (
 
 type DoorId int
 
+// Whether, and which way, a Door can be passed
+type DoorState int8
+
+const (
+	// Passable both ways
+	DOOR_OPEN DoorState = iota
+	// Impassable, like a locked door, but meant to be opened again, e.g. a
+	// shop's door outside its hours
+	DOOR_CLOSED
+	// Impassable
+	DOOR_LOCKED
+	// Passable only from the room at DoorSteps()[0] to the room at
+	// DoorSteps()[1]
+	DOOR_ONEWAY
+	NUM_DOOR_STATES
+)
+
+func (s DoorState) String() string {
+	switch s {
+	case DOOR_OPEN:
+		return "open"
+	case DOOR_CLOSED:
+		return "closed"
+	case DOOR_LOCKED:
+		return "locked"
+	case DOOR_ONEWAY:
+		return "one-way"
+	}
+	return fmt.Sprintf("DoorState(%d)", int(s))
+}
+
 type Door struct {
-	Id DoorId
-	O  Orientation
-	L  game.Location
-	R  [2]RoomId // The RoomId's on either side of the door
-	w  *World
+	Id    DoorId
+	O     Orientation
+	L     game.Location
+	R     [2]RoomId // The RoomId's on either side of the door
+	State DoorState
+	w     *World
 }
 
 func (w *World) NewDoor(l game.Location, o Orientation, m game.ModMap) (d *Door) {
@@ -34,17 +66,24 @@ func (w *World) NewDoor(l game.Location, o Orientation, m game.ModMap) (d *Door)
 		return nil
 	}
 	w.nextDoorId++
+	w.placeDoor(d, m)
+	return
+}
+
+// Stamps d into the DoorIds layer and connects it to its adjacent rooms
+func (w *World) placeDoor(d *Door, m game.ModMap) {
 	w.DoorIds.SetMask(d.L, patterns.DoorId, d.transpose(), game.TileId(d.Id), m)
+	d.modified()
 	d.updateRids()
 	for _, rid := range d.R {
 		if rid == 0 {
 			continue
 		}
+		w.touchRoom(rid)
 		r := w.Rooms[rid]
 		r.addDoorId(d.Id)
 	}
 	w.Doors[d.Id] = d
-	return
 }
 
 func (d *Door) updateRids() {
@@ -72,6 +111,63 @@ func (d *Door) Rooms() [2]*Room {
 	}
 }
 
+// Sets the state of door did. Returns false if there is no such door. Must
+// not be called during Think.
+func (w *World) SetDoorState(did DoorId, s DoorState) bool {
+	d := w.Doors[did]
+	if d == nil {
+		return false
+	}
+	if d.State == s {
+		return true
+	}
+	d.State = s
+	d.modified()
+	for _, rid := range d.R {
+		w.touchRoom(rid)
+	}
+	// Routes crossing d may no longer be walkable
+	m := game.NewModMap()
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			m.AddLocation(d.L.JustOffset(x, y))
+		}
+	}
+	w.touchBlocks(m)
+	return true
+}
+
+// Returns true if routes may cross d from the room at DoorSteps()[from],
+// i.e. if d is Passable that way
+func (d *Door) Routable(from int) bool {
+	dir := game.Direction(game.RIGHT)
+	if d.O == HORZ {
+		dir = game.DOWN
+	}
+	if from == 1 {
+		dir = dir.Reverse()
+	}
+	return d.Passable(dir)
+}
+
+// Returns true if an entity can step in direction dir onto the wall tiles
+// of d. This is the rule for every user of door states: World.StepEntity,
+// Routes, FlowFields and RouteWalkers' plans.
+func (d *Door) Passable(dir game.Direction) bool {
+	switch d.State {
+	case DOOR_CLOSED, DOOR_LOCKED:
+		return false
+	case DOOR_ONEWAY:
+		// Must be moving from side 0 towards side 1
+		dx, dy := d.L.SmallDistance(d.L.JustStep(dir))
+		if d.O == VERT {
+			return dx > 0
+		}
+		return dy > 0
+	}
+	return true
+}
+
 // Returns Locations in each of the two rooms joined by d
 func (d *Door) DoorSteps() [2]game.Location {
 	switch d.O {
@@ -112,21 +208,29 @@ func (w *World) CanPlaceDoor(l game.Location, o Orientation) bool {
 		w.DoorIds.Match(l, patterns.Zero4x3, transpose)
 }
 
-// Verifies consistency of Door
-func (d *Door) fsck() {
+// Verifies consistency of Door, and returns the inconsistencies found
+func (d *Door) fsck() (errs []FsckError) {
+	e := func(c FsckCategory, l game.Location, rid RoomId, msg string) {
+		errs = append(errs, FsckError{
+			Category: c,
+			Message:  msg,
+			L:        l,
+			RoomId:   rid,
+			DoorId:   d.Id,
+		})
+	}
 	// Ensure underlying wall pattern is valid
 	if !d.w.Walls.Match(d.L, patterns.Door, d.transpose()) {
-		panic("walls around door inconsistent")
+		e(FSCK_DOOR_WALLS, d.L, 0, "walls around door inconsistent")
 	}
 	// Ensure DoorIds are set
 	if !d.w.DoorIds.Match(d.L, patterns.DoorId.Remap(game.TileId(d.Id)), d.transpose()) {
-		panic("DoorId inconsistent")
+		e(FSCK_DOOR_IDS, d.L, 0, "DoorId inconsistent")
 	}
 	// Ensure RoomIds are consistent
 	for i, l := range d.DoorSteps() {
 		if got, actual := d.R[i], RoomId(d.w.RoomIds.Get(l)); got != actual {
-			fmt.Printf("got %d actual %d\n", got, actual)
-			panic("Door's stored RoomIds are inconsistent with RoomIds layer")
+			e(FSCK_DOOR_ROOMIDS, l, got, fmt.Sprintf("Door's stored RoomId %d is inconsistent with RoomIds layer %d", got, actual))
 		}
 	}
 	// Ensure connected rooms reference back to this Door
@@ -135,36 +239,42 @@ func (d *Door) fsck() {
 			continue
 		}
 		r := d.w.Rooms[rid]
+		if r == nil {
+			e(FSCK_DOOR_REFERENCE, d.L, rid, "Door references non-existent room")
+			continue
+		}
+		found := false
 		for _, did := range r.DoorIds {
 			if did == d.Id {
-				goto okay
+				found = true
 			}
 		}
-		// missing room->door reference
-		// see if some other room has a reference to this door
-		for rid, r := range d.w.Rooms {
-			for _, did := range r.DoorIds {
-				if did == d.Id {
-					fmt.Printf("rid: %d has reference to did %d\n", rid, d.Id)
-				}
-			}
+		if !found {
+			e(FSCK_DOOR_REFERENCE, d.L, rid, fmt.Sprintf("Missing Room->Door reference, r.DoorIds: %v", r.DoorIds))
+		}
+	}
+	return
+}
+
+// Marks the blocks under d as modified, see World.Hash
+func (d *Door) modified() {
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			d.w.mods.AddLocation(d.L.JustOffset(x, y))
 		}
-		fmt.Printf("rid: %d, did: %d d.R: %v r.DoorIds: %v\n", r.id, d.Id, d.R, r.DoorIds)
-		d.updateRids()
-		fmt.Printf("rid: %d, did: %d d.R: %v r.DoorIds: %v\n", r.id, d.Id, d.R, r.DoorIds)
-		panic("Missing Room->Door reference")
-	okay:
 	}
 }
 
 func (d *Door) Delete(m game.ModMap) {
 	// Clear DoorIds layer
 	d.w.DoorIds.SetMask(d.L, patterns.DoorId, d.transpose(), 0, m)
+	d.modified()
 	// Remove from adjacent rooms
 	for _, room := range d.Rooms() {
 		if room == nil {
 			continue
 		}
+		d.w.touchRoom(room.id)
 		room.removeDoorId(d.Id)
 	}
 	// Remove from world
@@ -0,0 +1,55 @@
+package world
+
+import (
+	"jds/game"
+	"testing"
+)
+
+func TestDoorState(t *testing.T) {
+	w := NewWorld(0)
+	l := game.Location{}
+	w.DrawBox(l, l.JustOffset(20, 10))
+	w.DrawLine(l.JustOffset(10, 0), l.JustOffset(10, 10))
+	d := w.NewDoor(l.JustOffset(9, 4), VERT, nil)
+	steps := d.DoorSteps()
+	open := w.Hash()
+	if w.SetDoorState(d.Id+1, DOOR_LOCKED) {
+		t.Error("set state of nonexistent door")
+	}
+	// Try to cross d from side 'from'. Returns true if the entity got across.
+	cross := func(from int) bool {
+		e := &savedEntity{l: steps[from]}
+		eid := EntityId(2)
+		sc := w.entityCursor(e.l)
+		sc.Set(0, game.TileId(eid))
+		defer sc.Set(0, ENTITYID_INVALID)
+		dir := game.Direction(game.RIGHT)
+		if from == 1 {
+			dir = dir.Reverse()
+		}
+		for i := 0; i < 2; i++ {
+			var ok bool
+			if e.l, ok = w.StepEntity(eid, e, &sc, dir); !ok {
+				return false
+			}
+		}
+		return e.l == steps[1-from]
+	}
+	for _, c := range []struct {
+		s           DoorState
+		there, back bool
+	}{
+		{DOOR_OPEN, true, true},
+		{DOOR_CLOSED, false, false},
+		{DOOR_LOCKED, false, false},
+		{DOOR_ONEWAY, true, false},
+	} {
+		w.SetDoorState(d.Id, c.s)
+		if cross(0) != c.there || cross(1) != c.back {
+			t.Errorf("%v door crossed wrong", c.s)
+		}
+		if h := w.Hash(); (h == open) != (c.s == DOOR_OPEN) {
+			t.Errorf("%v door has wrong hash", c.s)
+		}
+	}
+}
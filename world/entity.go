@@ -16,7 +16,8 @@ type Entity interface {
 	//
 	// E has spawned into world w with EntityId id. sc is a StackCursor
 	// with w.EntityIds and w.Walls as layers 0 and 1, respectively, and
-	// cursor position at E.Location()
+	// cursor position at E.Location(). Entities that need random numbers
+	// should get them from w.EntityRand(id).
 	Spawned(ta *ActionAccumulator, id EntityId, w *World, sc *layer.StackCursor)
 	// E has attempted to move to other's location, or other has attempted to
 	// move to E's location. 'd' is the direction of 'other' relative to E.
@@ -26,3 +27,12 @@ type Entity interface {
 	//
 	Color() game.Color
 }
+
+// An Entity that is told when it dies
+type Despawner interface {
+	Entity
+	// E has been removed from the World, at the end of the tick it was
+	// killed in, or immediately by World.Kill. Its pending Actions are
+	// cancelled, but Actions added to ta are not.
+	Despawned(ta *ActionAccumulator)
+}
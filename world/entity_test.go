@@ -0,0 +1,130 @@
+package world
+
+import (
+	"jds/game"
+	"jds/game/layer"
+	"testing"
+)
+
+// An Entity that acts every tick, and counts its Actions and Despawned
+// events
+type mortalEntity struct {
+	savedEntity
+	id        EntityId
+	acts      int
+	despawned int
+	// Called by each Act
+	act func(ta *ActionAccumulator)
+	// Called after Despawned
+	afterlife Action
+}
+
+func (e *mortalEntity) Spawned(ta *ActionAccumulator, id EntityId, w *World, sc *layer.StackCursor) {
+	e.id = id
+	ta.Add(w.Now()+1, e.Act, e.l.BlockId)
+}
+
+func (e *mortalEntity) Act(ta *ActionAccumulator) {
+	e.acts++
+	if e.act != nil {
+		e.act(ta)
+	}
+	ta.Add(ta.nextTick, e.Act, e.l.BlockId)
+}
+
+func (e *mortalEntity) Despawned(ta *ActionAccumulator) {
+	e.despawned++
+	if e.afterlife != nil {
+		ta.Add(ta.nextTick, e.afterlife, e.l.BlockId)
+	}
+}
+
+func TestKill(t *testing.T) {
+	w := NewWorld(0)
+	l := game.Location{}
+	a := &mortalEntity{savedEntity: savedEntity{l: l}}
+	b := &mortalEntity{savedEntity: savedEntity{l: l.JustOffset(1, 0)}}
+	aid, bid := w.Spawn(a), w.Spawn(b)
+	w.Think()
+	w.Think()
+	if a.acts != 1 || b.acts != 1 {
+		t.Fatal("entities didn't act")
+	}
+	// Killed from an Action, b still acts this tick, then dies at the end
+	// of it. Killing it twice does nothing.
+	afterlife := 0
+	b.afterlife = func(ta *ActionAccumulator) {
+		afterlife++
+	}
+	a.act = func(ta *ActionAccumulator) {
+		ta.Kill(bid)
+		ta.Kill(bid)
+		a.act = nil
+	}
+	w.Think()
+	if a.acts != 2 || b.acts != 2 || b.despawned != 1 || w.Entities[bid] != nil || w.EntityIds.Get(b.l) != 0 {
+		t.Fatal("kill from Action failed:", a.acts, b.acts, b.despawned)
+	}
+	// b's pending Act is cancelled, but the Action added by Despawned runs
+	w.Think()
+	if b.acts != 2 || afterlife != 1 || a.acts != 3 {
+		t.Error("b's Actions weren't cancelled, or afterlife didn't run:", b.acts, afterlife)
+	}
+	// Killed outside Think
+	if !w.Kill(aid) || w.Kill(aid) || a.despawned != 1 {
+		t.Fatal("World.Kill failed")
+	}
+	w.Think()
+	if a.acts != 3 || len(w.Entities) != 0 {
+		t.Error("a acted after World.Kill")
+	}
+	// Killed before its Spawned event
+	c := &mortalEntity{savedEntity: savedEntity{l: l}}
+	w.Kill(w.Spawn(c))
+	w.Think()
+	w.Think()
+	if c.acts != 0 || c.despawned != 1 || len(w.spawning) != 0 {
+		t.Error("entity spawned after being killed")
+	}
+}
+
+func TestActionHandles(t *testing.T) {
+	w := NewWorld(0)
+	e := &mortalEntity{savedEntity: savedEntity{l: game.Location{}}}
+	w.Spawn(e)
+	w.Think()
+	// Ticks at which each Action ran
+	ran := make(map[string][]game.Tick)
+	action := func(name string) Action {
+		return func(ta *ActionAccumulator) {
+			ran[name] = append(ran[name], w.Now())
+		}
+	}
+	var start game.Tick
+	e.act = func(ta *ActionAccumulator) {
+		e.act = nil
+		start = w.Now()
+		bid := e.l.BlockId
+		ta.AddHandle(start+1, action("cancelled"), bid).Cancel()
+		ta.Reschedule(ta.AddHandle(start+1, action("moved"), bid), start+3)
+		// Only the last of the keyed Actions runs
+		ta.AddKeyed("k", start+1, action("first"), bid)
+		ta.AddKeyed("k", start+2, action("second"), bid)
+		ta.AddKeyed("c", start+1, action("cancelled"), bid).Cancel()
+	}
+	for i := 0; i < 5; i++ {
+		w.Think()
+	}
+	if len(ran["cancelled"]) != 0 || len(ran["first"]) != 0 {
+		t.Error("cancelled Actions ran:", ran)
+	}
+	if m := ran["moved"]; len(m) != 1 || m[0] != start+3 {
+		t.Error("rescheduled Action ran at wrong tick:", m, start)
+	}
+	if s := ran["second"]; len(s) != 1 || s[0] != start+2 {
+		t.Error("keyed Action ran at wrong tick:", s, start)
+	}
+	if len(w.keyed) != 0 {
+		t.Error("keyed Actions not forgotten:", w.keyed)
+	}
+}
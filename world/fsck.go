@@ -0,0 +1,435 @@
+package world
+
+import (
+	"fmt"
+	"jds/game"
+	"jds/game/layer"
+	"jds/runstat"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of inconsistency found by Fsck
+type FsckCategory int
+
+const (
+	// A WallTreeNode is stored under the wrong Location
+	FSCK_NODE_LOCATION FsckCategory = iota
+	// A wall tile has a non-zero RoomId
+	FSCK_WALL_ROOMID
+	// Adjacent wall tiles are in different wall trees
+	FSCK_TREE_CONTINUITY
+	// A node's parent doesn't point back to it
+	FSCK_NEIGHBOR_POINTER
+	// A node's Depth is wrong
+	FSCK_NODE_DEPTH
+	// A Room and its linking node don't agree
+	FSCK_LINKING_NODE
+	// A Room's Area, or the RoomIds of its interior, are wrong
+	FSCK_ROOM_AREA
+	// A Room's interior is not connected
+	FSCK_ROOM_CONNECTED
+	// The walls around a Door don't match patterns.Door
+	FSCK_DOOR_WALLS
+	// The DoorIds layer under a Door is wrong
+	FSCK_DOOR_IDS
+	// A Door's RoomIds don't match the RoomIds layer
+	FSCK_DOOR_ROOMIDS
+	// A Door and a Room don't reference each other
+	FSCK_DOOR_REFERENCE
+	// A check panicked
+	FSCK_PANIC
+)
+
+var fsckCategoryNames = [...]string{
+	"node location",
+	"wall roomid",
+	"tree continuity",
+	"neighbor pointer",
+	"node depth",
+	"linking node",
+	"room area",
+	"room connected",
+	"door walls",
+	"door ids",
+	"door roomids",
+	"door reference",
+	"panic",
+}
+
+func (c FsckCategory) String() string {
+	if c >= 0 && int(c) < len(fsckCategoryNames) {
+		return fsckCategoryNames[c]
+	}
+	return fmt.Sprintf("FsckCategory(%d)", int(c))
+}
+
+// An inconsistency found by Fsck
+type FsckError struct {
+	Category FsckCategory
+	Message  string
+	// Location of the problem
+	L game.Location
+	// The Room and Door involved, if any
+	RoomId RoomId
+	DoorId DoorId
+	// Tiles to highlight, or nil
+	Layer *layer.Layer
+}
+
+func (e FsckError) Error() string {
+	s := fmt.Sprintf("%s: %s at %s", e.Category, e.Message, e.L)
+	if e.RoomId != 0 {
+		s += fmt.Sprintf(" rid %d", e.RoomId)
+	}
+	if e.DoorId != 0 {
+		s += fmt.Sprintf(" did %d", e.DoorId)
+	}
+	return s
+}
+
+// Returns e.Layer, or a layer with just e.L set if e.Layer is nil
+func (e FsckError) Highlight() *layer.Layer {
+	if e.Layer != nil {
+		return e.Layer
+	}
+	l := layer.NewLayer()
+	l.Set(e.L, 1)
+	return l
+}
+
+// The errors of a failed Fsck, as an error. Worlds with STRICT_FSCK_EVERY_OP
+// panic with FsckErrors.
+type FsckErrors []FsckError
+
+func (errs FsckErrors) Error() string {
+	s := make([]string, len(errs))
+	for i := range errs {
+		s[i] = errs[i].Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+// Collects FsckErrors
+type fsckState struct {
+	errs []FsckError
+}
+
+func (s *fsckState) add(errs ...FsckError) {
+	s.errs = append(s.errs, errs...)
+}
+
+// Runs f, recording an FSCK_PANIC error at l if it panics. Checks of
+// inconsistent structures can panic, and this lets Fsck carry on.
+func (s *fsckState) run(l game.Location, rid RoomId, did DoorId, f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.add(FsckError{
+				Category: FSCK_PANIC,
+				Message:  fmt.Sprint(r),
+				L:        l,
+				RoomId:   rid,
+				DoorId:   did,
+			})
+		}
+	}()
+	f()
+}
+
+// Verifies the consistency of the wall trees, Rooms and Doors of w, and
+// returns every inconsistency found, or nil. With STRICT_PARANOID, the
+// area and connectedness of every Room is verified by flood fill, which is
+// very slow.
+func (w *World) Fsck() []FsckError {
+	defer runstat.Record(time.Now(), "FsckWallTree")
+	s := &fsckState{}
+	w.fsckNodes(s)
+	// Map range traversal order is random, so sort keys first
+	rids := make([]int, 0)
+	for rid, r := range w.Rooms {
+		if r.id != rid {
+			s.add(FsckError{
+				Category: FSCK_LINKING_NODE,
+				Message:  fmt.Sprint("stored rid ", r.id, " doesn't match key"),
+				RoomId:   rid,
+			})
+			continue
+		}
+		rids = append(rids, int(rid))
+	}
+	sort.Ints(rids)
+	roomTiles := make(map[game.Location]bool)
+	if w.strict&STRICT_PARANOID != 0 {
+		for _, loc := range w.RoomIds.DeepSearchNonZero() {
+			roomTiles[loc] = true
+		}
+	}
+	for _, rid := range rids {
+		r := w.Rooms[RoomId(rid)]
+		s.run(r.LNL, r.id, 0, func() {
+			if w.fsckLinkingNode(s, r) {
+				w.fsckRoom(s, r, roomTiles)
+			}
+		})
+	}
+	// verify that every room tile was visited. this is vacuous if
+	// STRICT_PARANOID is not set (roomTiles uninitialized)
+	for l, v := range roomTiles {
+		if v {
+			s.add(FsckError{
+				Category: FSCK_ROOM_AREA,
+				Message:  "tile belongs to non-existent room",
+				L:        l,
+				RoomId:   RoomId(w.RoomIds.Get(l)),
+			})
+		}
+	}
+	// Check all doors
+	dids := make([]int, 0, len(w.Doors))
+	for did := range w.Doors {
+		dids = append(dids, int(did))
+	}
+	sort.Ints(dids)
+	for _, did := range dids {
+		d := w.Doors[DoorId(did)]
+		s.run(d.L, 0, d.Id, func() {
+			s.add(d.fsck()...)
+		})
+	}
+	return s.errs
+}
+
+// Checks every WallTreeNode
+func (w *World) fsckNodes(s *fsckState) {
+	seenRids := make(map[RoomId]game.Location)
+	for l, nn := range w.WallNodes {
+		// Check node location
+		if nn.L != l {
+			s.add(FsckError{
+				Category: FSCK_NODE_LOCATION,
+				Message:  fmt.Sprint("node stored at wrong location ", nn.L),
+				L:        l,
+			})
+		}
+		if rid := w.RoomIds.Get(nn.L); rid != 0 {
+			s.add(FsckError{
+				Category: FSCK_WALL_ROOMID,
+				Message:  "wall with non-zero roomid under it",
+				L:        nn.L,
+				RoomId:   RoomId(rid),
+			})
+		}
+		// All adjacent wall tiles should be in the same tree
+		s.add(w.checkRootContinuty(nn)...)
+		// Neighbor pointers
+		if nn.P != nil && nn.P.N[3-nn.D] != nn {
+			s.add(FsckError{
+				Category: FSCK_NEIGHBOR_POINTER,
+				Message:  fmt.Sprint("parent at ", nn.P.L, " doesn't point back to node"),
+				L:        nn.L,
+			})
+		}
+		// Check node's Room pointers
+		for rid, d := range nn.RoomIds {
+			e := FsckError{
+				Category: FSCK_LINKING_NODE,
+				L:        nn.L,
+				RoomId:   rid,
+			}
+			r := w.Rooms[rid]
+			switch {
+			case rid == ROOMID_INVALID:
+				e.Message = "node contains invalid room id"
+			case r == nil:
+				e.Message = "node contains reference to deleted room"
+			case nn.L != r.LNPL():
+				e.Message = "LNPL inconsistent"
+			case nn != r.LNP():
+				e.Message = "LNP inconsistent"
+			case d != r.LNPD:
+				e.Message = "LNPD inconsistent"
+			}
+			if e.Message != "" {
+				s.add(e)
+				continue
+			}
+			if seen, ok := seenRids[rid]; ok {
+				e.Message = fmt.Sprint("more than one LNP for rid, other at ", seen)
+				s.add(e)
+			}
+			seenRids[rid] = nn.L
+		}
+		// Check node depth
+		depth := 0
+		for nnn := nn; nnn != nnn.R; nnn = nnn.P {
+			if nnn.P == nil {
+				s.add(FsckError{
+					Category: FSCK_NODE_DEPTH,
+					Message:  fmt.Sprint("node at ", nnn.L, " has no parent and is not the root"),
+					L:        nn.L,
+				})
+				depth = nn.Depth
+				break
+			}
+			if nnn.Depth != nnn.P.Depth+1 {
+				s.add(FsckError{
+					Category: FSCK_NODE_DEPTH,
+					Message:  fmt.Sprint("depth ", nnn.Depth, " of node at ", nnn.L, " doesn't follow parent's ", nnn.P.Depth),
+					L:        nn.L,
+				})
+				depth = nn.Depth
+				break
+			}
+			depth++
+		}
+		if depth != nn.Depth {
+			s.add(FsckError{
+				Category: FSCK_NODE_DEPTH,
+				Message:  fmt.Sprint("node depth is ", nn.Depth, ", want ", depth),
+				L:        nn.L,
+			})
+		}
+	}
+}
+
+// Checks that r and its linking node agree. Returns false if they don't,
+// and r's interior can't be checked.
+func (w *World) fsckLinkingNode(s *fsckState, r *Room) bool {
+	e := FsckError{
+		Category: FSCK_LINKING_NODE,
+		L:        r.LNL,
+		RoomId:   r.id,
+	}
+	lnp := r.LNP()
+	if lnp == nil {
+		e.Message = "room's linking node parent is not a wall"
+		s.add(e)
+		return false
+	}
+	n1 := r.LinkingNode()
+	if n2 := w.WallNodes[n1.L]; n2 == nil || n1.R != n2.R {
+		e.Message = "linking node is from different tree"
+		e.Layer = layer.NewLayer()
+		e.Layer.Set(n1.L, 1)
+		s.add(e)
+		return false
+	}
+	if d, ok := lnp.RoomIds[r.id]; !ok {
+		e.Message = "room points to node, but node doesn't point to room"
+		s.add(e)
+	} else if d != r.LNPD {
+		e.Message = "r.LNP's direction inconsistent with r's"
+		s.add(e)
+	}
+	return true
+}
+
+// Checks the interior, area and Door references of r. See Fsck for
+// roomTiles.
+func (w *World) fsckRoom(s *fsckState, r *Room, roomTiles map[game.Location]bool) {
+	rid := r.id
+	interiorArea := 0
+	wrongTiles := make([]game.Location, 0)
+	r.paint(func(rm *game.RowMask, ridRow []game.TileId) bool {
+		// sanity check
+		if rm.Width() != len(ridRow) {
+			s.add(FsckError{
+				Category: FSCK_ROOM_AREA,
+				Message:  fmt.Sprint("row mask width ", rm.Width(), " doesn't match row ", len(ridRow)),
+				L:        rm.Left,
+				RoomId:   rid,
+			})
+			return false
+		}
+		for i := 0; i < rm.Width(); i++ {
+			inside, skip := rm.Mask(i)
+			if inside {
+				interiorArea++
+				if RoomId(ridRow[i]) != rid {
+					wrongTiles = append(wrongTiles, rm.Left)
+				}
+				rm.Left, _, _ = rm.Left.Right()
+			} else {
+				i += skip - 1
+				rm.Left, _, _ = rm.Left.Offset(skip, 0)
+			}
+		}
+		return true
+	})
+	if len(wrongTiles) != 0 {
+		s.add(FsckError{
+			Category: FSCK_ROOM_AREA,
+			Message:  fmt.Sprint(len(wrongTiles), " interior tiles have the wrong roomid"),
+			L:        wrongTiles[0],
+			RoomId:   rid,
+			Layer:    layer.NewLayerFromSlice(wrongTiles, game.TileId(rid)),
+		})
+	}
+	if interiorArea != r.Area {
+		roomTiles := w.RoomIds.DeepSearch(game.TileId(rid))
+		s.add(FsckError{
+			Category: FSCK_ROOM_AREA,
+			Message:  fmt.Sprint("interior area ", interiorArea, " actual area ", len(roomTiles), " recorded area ", r.Area),
+			L:        r.LNL,
+			RoomId:   rid,
+			Layer:    layer.NewLayerFromSlice(roomTiles, game.TileId(rid)),
+		})
+	}
+	nonempty, il := r.IsNonEmpty()
+	if !nonempty && r.Area != 0 {
+		s.add(FsckError{
+			Category: FSCK_ROOM_AREA,
+			Message:  "room is empty, but has positive area",
+			L:        r.LNL,
+			RoomId:   rid,
+		})
+	}
+	if w.strict&STRICT_PARANOID != 0 && nonempty {
+		// Exhaustively verify room area is correct and rooms are connected.
+		// very slow.
+		for _, l := range w.RoomIds.Flood(il) {
+			roomTiles[l] = false
+		}
+		s.add(r.checkConnected()...)
+	}
+	// Check Door references
+	for _, did := range r.DoorIds {
+		d := w.Doors[did]
+		if d == nil || (d.R[0] != rid && d.R[1] != rid) {
+			s.add(FsckError{
+				Category: FSCK_DOOR_REFERENCE,
+				Message:  "Missing Door->Room reference. Room contains stale DoorIds entry?",
+				L:        r.LNL,
+				RoomId:   rid,
+				DoorId:   did,
+			})
+		}
+	}
+}
+
+// Returns an FSCK_TREE_CONTINUITY error for each neighbor of n that is in a
+// different wall tree
+func (w *World) checkRootContinuty(n *WallTreeNode) (errs []FsckError) {
+	for _, nl := range n.L.Neighbors() {
+		if nn := w.WallNodes[nl]; nn != nil && nn.R != n.R {
+			errs = append(errs, FsckError{
+				Category: FSCK_TREE_CONTINUITY,
+				Message:  fmt.Sprint("adjacent tile at ", nn.L, " from different tree"),
+				L:        n.L,
+			})
+		}
+	}
+	return
+}
+
+// Panics with FsckErrors if w is inconsistent and STRICT_FSCK_EVERY_OP is
+// set
+func (w *World) strictFsck() {
+	if w.strict&STRICT_FSCK_EVERY_OP == 0 {
+		return
+	}
+	if errs := w.Fsck(); len(errs) != 0 {
+		panic(FsckErrors(errs))
+	}
+}
@@ -0,0 +1,66 @@
+package world
+
+import (
+	"jds/game"
+	"testing"
+)
+
+// Returns the categories of errs, and how often each occurs
+func categories(errs []FsckError) map[FsckCategory]int {
+	c := make(map[FsckCategory]int)
+	for _, e := range errs {
+		c[e.Category]++
+	}
+	return c
+}
+
+func TestFsck(t *testing.T) {
+	w := NewWorld(STRICT_ALL)
+	l := game.Location{}
+	w.DrawBox(l, l.JustOffset(40, 20))
+	w.DrawLine(l.JustOffset(20, 0), l.JustOffset(20, 20))
+	d := w.NewDoor(l.JustOffset(19, 8), VERT, nil)
+	if errs := w.Fsck(); errs != nil {
+		t.Fatal(FsckErrors(errs))
+	}
+	// Corrupt the world in several ways. Fsck reports all of them.
+	wall := l.JustOffset(30, 0)
+	w.RoomIds.Set(wall, game.TileId(d.R[0]))
+	d.R[1] = d.R[0]
+	r := w.Rooms[d.R[0]]
+	r.Area++
+	errs := w.Fsck()
+	c := categories(errs)
+	if c[FSCK_WALL_ROOMID] != 1 || c[FSCK_DOOR_ROOMIDS] != 1 || c[FSCK_ROOM_AREA] == 0 {
+		t.Fatal("wrong errors:\n", FsckErrors(errs))
+	}
+	for _, e := range errs {
+		switch e.Category {
+		case FSCK_WALL_ROOMID:
+			if e.L != wall || e.RoomId != r.id {
+				t.Error("wrong location or room:", e)
+			}
+		case FSCK_DOOR_ROOMIDS:
+			if e.DoorId != d.Id || e.L != d.DoorSteps()[1] {
+				t.Error("wrong door or location:", e)
+			}
+			if e.Highlight().Get(e.L) == 0 {
+				t.Error("location not highlighted")
+			}
+		}
+	}
+}
+
+func TestStrictFsckPanics(t *testing.T) {
+	w := NewWorld(STRICT_FSCK_EVERY_OP)
+	l := game.Location{}
+	w.DrawBox(l, l.JustOffset(20, 20))
+	w.RoomIds.Set(l.JustOffset(10, 0), 1)
+	defer func() {
+		errs, ok := recover().(FsckErrors)
+		if !ok || categories(errs)[FSCK_WALL_ROOMID] != 1 {
+			t.Fatal("expected FsckErrors panic, got", errs)
+		}
+	}()
+	w.SetWall(l.JustOffset(25, 25))
+}
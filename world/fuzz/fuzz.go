@@ -0,0 +1,260 @@
+// Package fuzz tests the wall tree by running random sequences of Box,
+// Delete and PlaceDoor operations against a World with STRICT_FSCK_EVERY_OP
+// set. When a sequence panics, it is shrunk to a small sequence that fails
+// the same way, which can be written out as a regression test.
+package fuzz
+
+import (
+	"fmt"
+	"io"
+	"jds/game"
+	"jds/game/world"
+	"math/rand"
+	"os"
+	"reflect"
+)
+
+type OpType uint8
+
+const (
+	// Draw a box with corners A and B
+	OP_BOX OpType = iota
+	// Delete the walls at A and B
+	OP_DELETE
+	// Place doors near A and B
+	OP_PLACEDOOR
+)
+
+var opNames = [...]string{"OP_BOX", "OP_DELETE", "OP_PLACEDOOR"}
+
+func (t OpType) String() string {
+	if int(t) < len(opNames) {
+		return opNames[t]
+	}
+	return fmt.Sprintf("OpType(%d)", t)
+}
+
+const (
+	// Operations happen in a GRID_SIZE square, on locations that are
+	// multiples of GRID_STEP from its top left corner
+	GRID_SIZE = 80
+	GRID_STEP = 5
+)
+
+// An operation on a World. A and B are offsets from the Location the
+// operations are applied at.
+type Op struct {
+	Type           OpType
+	AX, AY, BX, BY int
+}
+
+// Returns the Locations of A and B, relative to origin
+func (o Op) Locations(origin game.Location) (a, b game.Location) {
+	return origin.JustOffset(o.AX, o.AY), origin.JustOffset(o.BX, o.BY)
+}
+
+// Applies o to w, relative to origin
+func (o Op) Apply(w *world.World, origin game.Location) {
+	a, b := o.Locations(origin)
+	switch o.Type {
+	case OP_BOX:
+		w.DrawBox(a, b)
+	case OP_DELETE:
+		w.DeleteFromWallTree(a)
+		w.DeleteFromWallTree(b)
+	case OP_PLACEDOOR:
+		placeDoor(w, a)
+		placeDoor(w, b)
+	default:
+		panic(fmt.Sprint("unknown op ", o.Type))
+	}
+}
+
+// Places a door at or near l, if there is room for one, like the PlaceDoor
+// tool
+func placeDoor(w *world.World, l game.Location) {
+	canPlace := func(l game.Location) bool {
+		return w.CanPlaceDoor(l, world.VERT) || w.CanPlaceDoor(l, world.HORZ)
+	}
+	found, l := w.Walls.FuzzyMatch(l, canPlace)
+	if !found {
+		return
+	}
+	o := world.Orientation(world.VERT)
+	if !w.CanPlaceDoor(l, world.VERT) {
+		o = world.HORZ
+	}
+	w.NewDoor(l, o, nil)
+}
+
+// Returns a random Op
+func RandomOp(rng *rand.Rand) (o Op) {
+	o.AX = rng.Intn(GRID_SIZE/GRID_STEP) * GRID_STEP
+	o.AY = rng.Intn(GRID_SIZE/GRID_STEP) * GRID_STEP
+	o.BX = o.AX + rng.Intn(5)*2*GRID_STEP
+	o.BY = o.AY + rng.Intn(5)*2*GRID_STEP
+	switch rng.Intn(5) {
+	case 2:
+		o.Type = OP_BOX
+	case 0, 4:
+		o.Type = OP_PLACEDOOR
+	default:
+		o.Type = OP_DELETE
+	}
+	return
+}
+
+// Describes the panic of a sequence of Ops
+type Failure struct {
+	// Index of the Op that panicked
+	Op int
+	// The recovered value, and world.LastOp at the time
+	Err    interface{}
+	LastOp interface{}
+}
+
+func (f *Failure) Error() string {
+	return fmt.Sprintf("op %d: %v (last op %v)", f.Op, f.Err, f.LastOp)
+}
+
+// Two Failures are the same if their panics have the same type and, for
+// world.FsckErrors, the same FsckCategories, or otherwise the same message.
+// The messages of FsckErrors name Locations, RoomIds and DoorIds, which shift
+// as Shrink removes Ops, so they aren't compared.
+func (f *Failure) same(g *Failure) bool {
+	if g == nil || reflect.TypeOf(f.Err) != reflect.TypeOf(g.Err) {
+		return false
+	}
+	if errs, ok := f.Err.(world.FsckErrors); ok {
+		return categories(errs) == categories(g.Err.(world.FsckErrors))
+	}
+	return fmt.Sprint(f.Err) == fmt.Sprint(g.Err)
+}
+
+// Returns the set of FsckCategories of errs, as a bit mask
+func categories(errs world.FsckErrors) (set uint64) {
+	for _, e := range errs {
+		set |= 1 << uint(e.Category)
+	}
+	return
+}
+
+func newWorld() *world.World {
+	return world.NewWorld(world.STRICT_FSCK_EVERY_OP)
+}
+
+// Applies o to w, and returns a Failure if it panics
+func apply(w *world.World, i int, o Op) (f *Failure) {
+	defer func() {
+		if r := recover(); r != nil {
+			f = &Failure{
+				Op:     i,
+				Err:    r,
+				LastOp: world.LastOp,
+			}
+		}
+	}()
+	o.Apply(w, game.Location{})
+	return nil
+}
+
+// Runs ops against a new World, and returns the Failure of the first Op that
+// panics, or nil
+func Run(ops []Op) *Failure {
+	w := newWorld()
+	defer w.Discard()
+	for i, o := range ops {
+		if f := apply(w, i, o); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// Applies up to n random Ops to a new World, stopping at the first Op that
+// panics. Returns the Ops applied, and the Failure or nil.
+func Find(rng *rand.Rand, n int) ([]Op, *Failure) {
+	w := newWorld()
+	defer w.Discard()
+	ops := make([]Op, 0, n)
+	for i := 0; i < n; i++ {
+		o := RandomOp(rng)
+		ops = append(ops, o)
+		if f := apply(w, i, o); f != nil {
+			return ops, f
+		}
+	}
+	return ops, nil
+}
+
+// Given ops, which fail with f, returns a shorter sequence of Ops that fails
+// the same way, and its Failure. Runs of Ops are removed, halving the length
+// of the runs each time no run can be removed, until no single Op can be
+// removed.
+func Shrink(ops []Op, f *Failure) ([]Op, *Failure) {
+	ops = ops[:f.Op+1]
+	for chunk := len(ops) / 2; chunk >= 1; {
+		removed := false
+		for start := 0; start < len(ops); {
+			end := start + chunk
+			if end > len(ops) {
+				end = len(ops)
+			}
+			try := make([]Op, 0, len(ops)-(end-start))
+			try = append(try, ops[:start]...)
+			try = append(try, ops[end:]...)
+			if g := Run(try); f.same(g) {
+				ops, f = try[:g.Op+1], g
+				removed = true
+			} else {
+				start = end
+			}
+		}
+		if !removed {
+			chunk /= 2
+		} else if chunk > len(ops)/2 {
+			chunk = len(ops) / 2
+		}
+	}
+	return ops, f
+}
+
+// Writes a Go test called Test<name>, that fails while ops fail with f. The
+// test is in package fuzz, and is meant to be saved in this directory, where
+// it can use the Check helper of the package's tests.
+func WriteTest(out io.Writer, name string, ops []Op, f *Failure) error {
+	_, err := fmt.Fprintf(out, `// Code generated by fuzz.WriteTest. DO NOT EDIT.
+
+package fuzz
+
+import "testing"
+
+// Failed with: %q
+func Test%s(t *testing.T) {
+	Check(t, []Op{
+`, f.Error(), name)
+	if err != nil {
+		return err
+	}
+	for _, o := range ops {
+		_, err = fmt.Fprintf(out, "\t\t{%v, %d, %d, %d, %d},\n", o.Type, o.AX, o.AY, o.BX, o.BY)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprint(out, "\t})\n}\n")
+	return err
+}
+
+// Writes the test from WriteTest to the file at path
+func WriteTestFile(path, name string, ops []Op, f *Failure) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = WriteTest(file, name, ops, f); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,129 @@
+package fuzz
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"jds/game"
+	"jds/game/world"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+var (
+	searchOps  = flag.Int("fuzz.ops", 200, "number of random ops for TestSearch")
+	searchSeed = flag.Int64("fuzz.seed", 1, "seed for TestSearch")
+)
+
+// Fails t if ops panic. Used by the tests written by WriteTest.
+func Check(t testing.TB, ops []Op) {
+	t.Helper()
+	if f := Run(ops); f != nil {
+		t.Fatal(f)
+	}
+}
+
+// Runs random ops, and writes a regression test for the shrunk sequence if
+// they fail
+func TestSearch(t *testing.T) {
+	ops, f := Find(rand.New(rand.NewSource(*searchSeed)), *searchOps)
+	if f == nil {
+		return
+	}
+	t.Log("failed after", len(ops), "ops:", f)
+	ops, f = Shrink(ops, f)
+	name := fmt.Sprintf("Regression%d", *searchSeed)
+	path := fmt.Sprintf("regression_%d_test.go", *searchSeed)
+	if err := WriteTestFile(path, name, ops, f); err != nil {
+		t.Fatal(err)
+	}
+	t.Fatal("shrunk to", len(ops), "ops, written to", path)
+}
+
+func TestShrink(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	bad := Op{Type: 99}
+	ops := make([]Op, 0)
+	for i := 0; i < 40; i++ {
+		ops = append(ops, RandomOp(rng))
+	}
+	ops = append(ops, bad)
+	for i := 0; i < 10; i++ {
+		ops = append(ops, RandomOp(rng))
+	}
+	f := Run(ops)
+	if f == nil || f.Op != 40 {
+		t.Fatal("expected failure at op 40, got", f)
+	}
+	ops, f = Shrink(ops, f)
+	if len(ops) != 1 || ops[0] != bad || f.Op != 0 {
+		t.Fatal("not shrunk:", ops, f)
+	}
+	var b bytes.Buffer
+	if err := WriteTest(&b, "Shrunk", ops, f); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "shrunk_test.go", b.Bytes(), 0); err != nil {
+		t.Fatal(err, "\n", b.String())
+	}
+	if !strings.Contains(b.String(), "{OpType(99), 0, 0, 0, 0},") {
+		t.Error("op missing from test:\n", b.String())
+	}
+}
+
+func TestFailureSame(t *testing.T) {
+	fsck := func(l game.Location, rid world.RoomId, cs ...world.FsckCategory) *Failure {
+		var errs world.FsckErrors
+		for _, c := range cs {
+			errs = append(errs, world.FsckError{Category: c, L: l, RoomId: rid})
+		}
+		return &Failure{Err: errs}
+	}
+	l := game.Location{}
+	f := fsck(l, 2, world.FSCK_ROOM_AREA, world.FSCK_WALL_ROOMID)
+	// Locations and RoomIds shift as Ops are removed
+	if !f.same(fsck(l.JustOffset(5, 5), 7, world.FSCK_WALL_ROOMID, world.FSCK_ROOM_AREA, world.FSCK_ROOM_AREA)) {
+		t.Error("failures with the same categories differ")
+	}
+	if f.same(fsck(l, 2, world.FSCK_ROOM_AREA)) || f.same(&Failure{Err: "room area"}) || f.same(nil) {
+		t.Error("different failures are the same")
+	}
+	if !(&Failure{Err: "x"}).same(&Failure{Err: "x"}) || (&Failure{Err: "x"}).same(&Failure{Err: "y"}) {
+		t.Error("panic messages compared wrong")
+	}
+}
+
+// Returns the Ops described by data, 5 bytes per Op
+func decode(data []byte) (ops []Op) {
+	const cells = GRID_SIZE / GRID_STEP
+	for ; len(data) >= 5; data = data[5:] {
+		o := Op{
+			Type: OpType(data[0] % 3),
+			AX:   int(data[1]%cells) * GRID_STEP,
+			AY:   int(data[2]%cells) * GRID_STEP,
+		}
+		o.BX = o.AX + int(data[3]%5)*2*GRID_STEP
+		o.BY = o.AY + int(data[4]%5)*2*GRID_STEP
+		ops = append(ops, o)
+	}
+	return
+}
+
+func FuzzOps(f *testing.F) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 4; i++ {
+		data := make([]byte, 5*20)
+		rng.Read(data)
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Every op runs Fsck, so long inputs are very slow
+		if len(data) > 5*50 {
+			data = data[:5*50]
+		}
+		Check(t, decode(data))
+	})
+}
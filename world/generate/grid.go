@@ -7,15 +7,19 @@ import (
 	"math/rand"
 )
 
-func NewGridWorld(width, height int) (w *world.World) {
+// Returns a World with a grid of rooms. The layout, and the World's seed (see
+// World.SetSeed), are determined by seed.
+func NewGridWorld(width, height int, seed int64) (w *world.World) {
 	w = world.NewWorld(0)
+	w.SetSeed(seed)
+	rng := rand.New(rand.NewSource(seed))
 	floorplan := layer.NewLayer()
 	cursor := game.Location{}.JustOffset(10, 10)
 	cellX := make([]int, width+1)
 	cellY := make([]int, height+1)
 	for j := 0; j < height; j++ {
 		for i := 0; i < width; i++ {
-			n := rand.Intn(6) + 2
+			n := rng.Intn(6) + 2
 			if j%3 == 0 || i%4 == 0 {
 				n = 1
 			}
@@ -23,10 +27,10 @@ func NewGridWorld(width, height int) (w *world.World) {
 		}
 	}
 	for i := 1; i <= width; i++ {
-		cellX[i] = cellX[i-1] + rand.Intn(8) + 8
+		cellX[i] = cellX[i-1] + rng.Intn(8) + 8
 	}
 	for i := 1; i <= height; i++ {
-		cellY[i] = cellY[i-1] + rand.Intn(6) + 12
+		cellY[i] = cellY[i-1] + rng.Intn(6) + 12
 	}
 	//w.DrawBox(
 	//	cursor,
@@ -2,11 +2,22 @@ package generate
 
 import (
 	"jds/game"
+	"math/rand"
 	"testing"
 )
 
+func TestGridWorldSeed(t *testing.T) {
+	w1, w2, w3 := NewGridWorld(5, 5, 1), NewGridWorld(5, 5, 1), NewGridWorld(5, 5, 2)
+	if !w1.Walls.Equal(w2.Walls) || w1.Seed() != w2.Seed() {
+		t.Error("worlds with the same seed differ")
+	}
+	if w1.Walls.Equal(w3.Walls) {
+		t.Error("worlds with different seeds are the same")
+	}
+}
+
 func BenchmarkInterior(b *testing.B) {
-	w := NewGridWorld(10, 10) //NewWorld(0)
+	w := NewGridWorld(10, 10, rand.Int63()) //NewWorld(0)
 	i := 0
 	for {
 		for _, v := range w.Rooms {
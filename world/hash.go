@@ -0,0 +1,117 @@
+package world
+
+import (
+	"jds/game"
+	"jds/game/layer"
+)
+
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+// Returns a digest of the Walls, RoomIds, DoorIds and EntityIds layers, the
+// states of the Doors, and the states of the Entities. Worlds with the same
+// contents have the same Hash, so it can be used to check that two runs of a
+// deterministic World (see SetSeed) agree.
+//
+// The layers are hashed block by block. Only blocks modified since the last
+// call, and blocks that have or had entities, are rehashed. Likewise each
+// Entity has a digest, which is only recomputed if it has spawned, stepped,
+// died or had an Action run since the last call. Entities that implement
+// EntityMarshaler are hashed by their marshaled state as well as their
+// Location. Must not be called during Think.
+func (w *World) Hash() uint64 {
+	// EntityIds changes during Think aren't tracked, so rehash every block
+	// that had an entity at the last Hash, or has one now
+	entityBlocks := game.NewModMap()
+	for _, e := range w.Entities {
+		entityBlocks.AddLocation(e.Location())
+	}
+	for eid := range w.entityMods {
+		w.entitiesHash -= w.entityHash[eid]
+		if e := w.Entities[eid]; e != nil {
+			h := hashEntity(eid, e)
+			w.entityHash[eid] = h
+			w.entitiesHash += h
+		} else {
+			delete(w.entityHash, eid)
+		}
+		delete(w.entityMods, eid)
+	}
+	w.mods.Merge(w.entityBlocks)
+	w.mods.Merge(entityBlocks)
+	w.entityBlocks = entityBlocks
+	for bid := range w.mods {
+		w.layersHash -= w.blockHash[bid]
+		if h := w.hashBlock(bid); h != 0 {
+			w.blockHash[bid] = h
+			w.layersHash += h
+		} else {
+			delete(w.blockHash, bid)
+		}
+		delete(w.mods, bid)
+	}
+	doors := uint64(0)
+	for did, d := range w.Doors {
+		doors += mix(uint64(did), uint64(d.State))
+	}
+	return mix(mix(mix(fnvOffset, w.layersHash), w.entitiesHash), doors)
+}
+
+// Marks the digest of Entity eid as stale, see Hash
+func (w *World) touchEntity(eid EntityId) {
+	w.entityMutex.Lock()
+	w.entityMods[eid] = true
+	w.entityMutex.Unlock()
+}
+
+// Returns the FNV-1a hash of the tiles of block bid in layers ls
+func hashTiles(bid game.BlockId, ls ...*layer.Layer) uint64 {
+	h := uint64(fnvOffset)
+	for _, l := range ls {
+		h = l.HashBlock(bid, h)
+	}
+	return h
+}
+
+// hashTiles of a block that is zero in all 4 hashed layers
+var zeroBlockHash = func() uint64 {
+	l := layer.NewLayer()
+	return hashTiles(game.BlockId{}, l, l, l, l)
+}()
+
+// Returns the hash of block bid, or 0 if it is zero in every hashed layer
+func (w *World) hashBlock(bid game.BlockId) uint64 {
+	h := hashTiles(bid, w.Walls, w.RoomIds, w.DoorIds, w.EntityIds)
+	if h == zeroBlockHash {
+		return 0
+	}
+	return mix(mix(h, uint64(bid.X)), uint64(bid.Y))
+}
+
+// Returns the digest of Entity e, which has EntityId eid
+func hashEntity(eid EntityId, e Entity) uint64 {
+	l := e.Location()
+	h := mix(fnvOffset, uint64(eid))
+	h = mix(h, uint64(l.BlockId.X))
+	h = mix(h, uint64(l.BlockId.Y))
+	h = mix(h, uint64(uint8(l.X))<<8|uint64(uint8(l.Y)))
+	if m, ok := e.(EntityMarshaler); ok {
+		if data, err := m.Marshal(); err == nil {
+			for _, b := range []byte(m.EntityType()) {
+				h = (h ^ uint64(b)) * fnvPrime
+			}
+			for _, b := range data {
+				h = (h ^ uint64(b)) * fnvPrime
+			}
+		}
+	}
+	return h
+}
+
+// Mixes v into h
+func mix(h, v uint64) uint64 {
+	r := NewRand(h ^ v)
+	return r.Uint64()
+}
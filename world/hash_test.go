@@ -0,0 +1,105 @@
+package world
+
+import (
+	"jds/game"
+	"jds/game/layer"
+	"math/rand"
+	"testing"
+)
+
+// Returns the Hash of w computed from scratch, rather than incrementally
+func rehash(w *World) uint64 {
+	w.blockHash = make(map[game.BlockId]uint64)
+	w.layersHash = 0
+	w.entityHash = make(map[EntityId]uint64)
+	w.entitiesHash = 0
+	for eid := range w.Entities {
+		w.touchEntity(eid)
+	}
+	for _, l := range []*layer.Layer{w.Walls, w.RoomIds, w.DoorIds, w.EntityIds} {
+		for _, bid := range l.NonZeroBlocks() {
+			w.mods.AddBlock(bid)
+		}
+	}
+	return w.Hash()
+}
+
+func TestHashIncremental(t *testing.T) {
+	w := NewWorld(0)
+	rng := rand.New(rand.NewSource(1))
+	l := game.Location{}
+	// A 4x4 grid of rooms with doors between them
+	for i := 0; i <= 4; i++ {
+		w.DrawLine(l.JustOffset(20*i, 0), l.JustOffset(20*i, 80))
+		w.DrawLine(l.JustOffset(0, 20*i), l.JustOffset(80, 20*i))
+	}
+	for i := 1; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			w.NewDoor(l.JustOffset(20*i-1, 20*j+8), VERT, nil)
+			w.NewDoor(l.JustOffset(20*j+8, 20*i-1), HORZ, nil)
+		}
+	}
+	if h := w.Hash(); h != rehash(w) {
+		t.Fatal("incremental hash differs")
+	}
+	rl := func() game.Location {
+		return l.JustOffset(rng.Intn(81), rng.Intn(81))
+	}
+	for i := 0; i < 200; i++ {
+		switch rng.Intn(5) {
+		case 0:
+			// Delete part of the grid, merging rooms
+			a := l.JustOffset(20*rng.Intn(5), 20*rng.Intn(5))
+			for j := 0; j < 20; j++ {
+				w.DeleteFromWallTree(a.JustOffset(j*(i%2), j*(1-i%2)))
+			}
+		case 1:
+			w.Spawn(&savedEntity{l: rl()})
+		case 2:
+			for eid := range w.Entities {
+				w.Kill(eid)
+				break
+			}
+		case 3:
+			for _, d := range w.Doors {
+				d.Delete(nil)
+				break
+			}
+		default:
+			w.DrawLine(rl(), rl())
+		}
+		if h := w.Hash(); h != rehash(w) {
+			t.Fatal("incremental hash differs after", i, "edits")
+		}
+	}
+}
+
+func TestHashChanges(t *testing.T) {
+	w := NewWorld(0)
+	l := game.Location{}
+	w.DrawBox(l, l.JustOffset(40, 40))
+	empty := w.Hash()
+	// A wall that doesn't change any rooms, once removed, leaves no trace
+	w.DrawLine(l.JustOffset(10, 1), l.JustOffset(10, 20))
+	if w.Hash() == empty {
+		t.Error("hash didn't change after adding wall")
+	}
+	for y := 1; y <= 20; y++ {
+		w.DeleteFromWallTree(l.JustOffset(10, y))
+	}
+	if w.Hash() != empty {
+		t.Error("hash changed after removing wall")
+	}
+	// Entities are part of the hash
+	e := &savedEntity{l: l.JustOffset(5, 5)}
+	w.Spawn(e)
+	spawned := w.Hash()
+	if spawned == empty {
+		t.Error("hash didn't change after spawn")
+	}
+	sc := w.entityCursor(e.l)
+	e.l, _ = w.StepEntity(EntityId(sc.Get(0)), e, &sc, game.RIGHT)
+	if w.Hash() == spawned {
+		t.Error("hash didn't change after entity moved")
+	}
+}
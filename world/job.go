@@ -0,0 +1,107 @@
+// Jobs, work done between ticks
+//
+// Work too slow for an Action, such as finding a long Route, can be submitted
+// as a job through an ActionAccumulator. At the end of each tick, once every
+// Action has run and the World has stopped changing, the oldest queued jobs
+// run. The Action receiving each job's result runs on the next tick.
+//
+// Jobs run one at a time. Route planning reads the World's layers through
+// StackCursors, which allocate and link missing blocks as they go, so two
+// jobs planning routes at once would race.
+//
+// Jobs run until JOB_BUDGET has elapsed, so a burst of jobs, e.g. from a
+// crowd spawning at once, is spread over several ticks rather than stalling
+// one, and as many jobs run as time allows. Which tick a job finishes on must
+// not depend on the speed of the machine in a deterministic World (see
+// SetSeed), so it instead runs a count of jobs that grows with the queue: at
+// least JOBS_PER_TICK, and enough to drain the queue in about
+// JOB_DRAIN_TICKS ticks.
+
+package world
+
+import (
+	"jds/game"
+	"time"
+)
+
+const (
+	JOB_BUDGET      = 4 * time.Millisecond
+	JOBS_PER_TICK   = 64
+	JOB_DRAIN_TICKS = 16
+)
+
+type job struct {
+	run   func()
+	done  Action
+	bid   game.BlockId
+	owner EntityId
+}
+
+// Queues 'run' to be called between ticks, and 'done' to be scheduled in
+// block bid on the tick after it returns. run must not modify the World.
+// Jobs run one at a time, oldest first. Like an Action added by Add, done
+// belongs to the Entity submitting the job. The job is dropped if that
+// Entity dies first. Jobs aren't saved in snapshots, so an Entity waiting for
+// one should submit it again when Restored.
+func (aa *ActionAccumulator) Submit(run func(), done Action, bid game.BlockId) {
+	if aa.IsClosed() {
+		panic("add to closed ActionAccumulator")
+	}
+	aa.jobs = append(aa.jobs, job{
+		run:   run,
+		done:  done,
+		bid:   bid,
+		owner: aa.owner,
+	})
+}
+
+// Returns the number of jobs waiting to run
+func (w *World) PendingJobs() int {
+	return len(w.jobs)
+}
+
+// Returns the number of jobs a deterministic World runs after this tick
+func (w *World) jobLimit() int {
+	if limit := (len(w.jobs) + JOB_DRAIN_TICKS - 1) / JOB_DRAIN_TICKS; limit > JOBS_PER_TICK {
+		return limit
+	}
+	return JOBS_PER_TICK
+}
+
+// Runs the oldest jobs until JOB_BUDGET has elapsed, or jobLimit of them if w
+// is deterministic, and schedules their Actions for the next tick. At least
+// one job runs, however long it takes.
+func (w *World) runJobs() {
+	start := time.Now()
+	limit := w.jobLimit()
+	var done []job
+	n := 0
+	for ; n < len(w.jobs); n++ {
+		if w.deterministic && len(done) == limit {
+			break
+		}
+		if !w.deterministic && len(done) > 0 && time.Since(start) >= JOB_BUDGET {
+			break
+		}
+		j := w.jobs[n]
+		if j.owner != ENTITYID_INVALID && w.Entities[j.owner] == nil {
+			continue
+		}
+		j.run()
+		done = append(done, j)
+	}
+	w.jobs = append(w.jobs[:0], w.jobs[n:]...)
+	w.ThinkStats.JobQueue = len(w.jobs)
+	if len(done) == 0 {
+		return
+	}
+	aa := w.allocateAA(w.ticks + 1)
+	for _, j := range done {
+		aa.owner = j.owner
+		aa.Add(w.ticks+1, j.done, j.bid)
+	}
+	aa.Close()
+	w.process(aa, false)
+	ReleaseAA(aa)
+	w.ThinkStats.Jobs += len(done)
+}
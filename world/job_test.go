@@ -0,0 +1,113 @@
+package world
+
+import (
+	"jds/game"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJobs(t *testing.T) {
+	w := NewWorld(0)
+	w.SetSeed(1)
+	l := game.Location{}
+	a := &mortalEntity{savedEntity: savedEntity{l: l}}
+	b := &mortalEntity{savedEntity: savedEntity{l: l.JustOffset(1, 0)}}
+	w.Spawn(a)
+	w.Spawn(b)
+	w.Think()
+	var ran int32
+	var m sync.Mutex
+	// Ticks at which the jobs' Actions ran
+	done := make(map[game.Tick]int)
+	n := JOBS_PER_TICK + 10
+	var start game.Tick
+	a.act = func(ta *ActionAccumulator) {
+		a.act = nil
+		start = w.Now()
+		for i := 0; i < n; i++ {
+			ta.Submit(func() {
+				atomic.AddInt32(&ran, 1)
+			}, func(ta *ActionAccumulator) {
+				m.Lock()
+				done[w.Now()]++
+				m.Unlock()
+			}, l.BlockId)
+		}
+	}
+	// Jobs of dead Entities are dropped
+	b.act = func(ta *ActionAccumulator) {
+		b.act = nil
+		ta.Submit(func() {
+			t.Error("job of dead entity ran")
+		}, func(ta *ActionAccumulator) {
+			t.Error("job of dead entity finished")
+		}, l.BlockId)
+		ta.Kill(b.id)
+	}
+	// A deterministic World runs only JOBS_PER_TICK jobs after each tick
+	w.Think()
+	if ran != JOBS_PER_TICK || w.PendingJobs() == 0 || w.ThinkStats.JobQueue != w.PendingJobs() || len(done) != 0 {
+		t.Fatal("wrong jobs run after first tick:", ran, w.PendingJobs(), done)
+	}
+	w.Think()
+	if ran != int32(n) || w.PendingJobs() != 0 || done[start+1] != JOBS_PER_TICK {
+		t.Fatal("wrong jobs run after second tick:", ran, w.PendingJobs(), done)
+	}
+	w.Think()
+	if done[start+2] != n-JOBS_PER_TICK || w.ThinkStats.Jobs != n {
+		t.Error("jobs' Actions didn't run:", done, w.ThinkStats.Jobs)
+	}
+}
+
+func TestJobBudget(t *testing.T) {
+	w := NewWorld(0)
+	l := game.Location{}
+	a := &mortalEntity{savedEntity: savedEntity{l: l}}
+	w.Spawn(a)
+	w.Think()
+	ran := 0
+	a.act = func(ta *ActionAccumulator) {
+		a.act = nil
+		for i := 0; i < 3; i++ {
+			ta.Submit(func() {
+				ran++
+				time.Sleep(JOB_BUDGET)
+			}, func(ta *ActionAccumulator) {}, l.BlockId)
+		}
+	}
+	// Each job uses up the budget, so one runs after each tick
+	for i := 1; i <= 3; i++ {
+		w.Think()
+		if ran != i || w.ThinkStats.JobQueue != 3-i {
+			t.Fatal("wrong jobs run after tick", i, ":", ran, w.ThinkStats.JobQueue)
+		}
+	}
+}
+
+func TestJobElapsed(t *testing.T) {
+	w := NewWorld(0)
+	// No Actions, so the tick only runs the job
+	w.jobs = []job{{
+		run:  func() { time.Sleep(JOB_BUDGET) },
+		done: func(ta *ActionAccumulator) {},
+	}}
+	w.Think()
+	if w.ThinkStats.Elapsed < JOB_BUDGET {
+		t.Error("time spent on jobs not counted:", w.ThinkStats.Elapsed)
+	}
+}
+
+func TestJobLimit(t *testing.T) {
+	w := NewWorld(0)
+	w.jobs = make([]job, JOBS_PER_TICK)
+	if w.jobLimit() != JOBS_PER_TICK {
+		t.Error("wrong limit for short queue:", w.jobLimit())
+	}
+	// A long queue drains in JOB_DRAIN_TICKS
+	w.jobs = make([]job, 100*JOB_DRAIN_TICKS*JOBS_PER_TICK)
+	if w.jobLimit() != 100*JOBS_PER_TICK {
+		t.Error("wrong limit for long queue:", w.jobLimit())
+	}
+}
@@ -0,0 +1,129 @@
+// Caches Routes between the Doors of a room
+
+package path
+
+import (
+	"jds/game/world"
+	"sync"
+)
+
+type doorPair struct {
+	From, To world.DoorId
+}
+
+type cachedRoute struct {
+	Route Route
+	Ok    bool // false if there is no Route
+}
+
+// The cached Routes of a single room, valid while the room's generation is
+// unchanged
+type roomRoutes struct {
+	gen    uint64
+	routes map[doorPair]cachedRoute
+}
+
+// A DoorCache stores the Routes inside each room between pairs of its Doors.
+// Entries for a room are discarded when World.RoomGeneration reports that the
+// room has changed.
+type DoorCache struct {
+	w     *world.World
+	m     sync.Mutex
+	rooms map[world.RoomId]*roomRoutes
+	// World.Generation when stale rooms were last discarded
+	purged uint64
+	// Cache statistics
+	Hits, Misses int
+}
+
+// Returns the DoorCache for World w, creating it if needed
+func Cache(w *world.World) *DoorCache {
+	return w.CustomData("path.DoorCache", func() interface{} {
+		return &DoorCache{
+			w:     w,
+			rooms: make(map[world.RoomId]*roomRoutes),
+		}
+	}).(*DoorCache)
+}
+
+// Returns the index into d.DoorSteps() of the tile inside room rid, or -1
+// if d doesn't join rid
+func doorSide(d *world.Door, rid world.RoomId) int {
+	for i := range d.R {
+		if d.R[i] == rid {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the Route inside room rid from the DoorStep of Door 'from' to the
+// DoorStep of Door 'to'. ok is false if there is no such Route.
+//
+// The returned Route is shared with the cache and must not be modified.
+func (c *DoorCache) Route(rid world.RoomId, from, to *world.Door) (route Route, ok bool) {
+	gen := c.w.RoomGeneration(rid)
+	key := doorPair{from.Id, to.Id}
+	c.m.Lock()
+	if g := c.w.Generation(); g != c.purged {
+		// some rooms changed, so entries for rooms that are never looked
+		// up again would otherwise stay forever
+		c.purge()
+		c.purged = g
+	}
+	rr := c.rooms[rid]
+	if rr != nil && rr.gen == gen {
+		if cr, hit := rr.routes[key]; hit {
+			c.Hits++
+			c.m.Unlock()
+			return cr.Route, cr.Ok
+		}
+	}
+	c.Misses++
+	c.m.Unlock()
+	fromSide, toSide := doorSide(from, rid), doorSide(to, rid)
+	if fromSide == -1 || toSide == -1 {
+		return nil, false
+	}
+	route, ok = roomRoute(c.w, from.DoorSteps()[fromSide], to.DoorSteps()[toSide], nil)
+	c.m.Lock()
+	rr = c.rooms[rid]
+	if rr == nil || rr.gen != gen {
+		// room changed since the entries were made, discard them
+		rr = &roomRoutes{
+			gen:    gen,
+			routes: make(map[doorPair]cachedRoute),
+		}
+		c.rooms[rid] = rr
+	}
+	rr.routes[key] = cachedRoute{route, ok}
+	c.m.Unlock()
+	return
+}
+
+// Returns the length of the Route inside room rid between Doors 'from' and
+// 'to', or -1 if there is no Route.
+func (c *DoorCache) Len(rid world.RoomId, from, to *world.Door) int {
+	route, ok := c.Route(rid, from, to)
+	if !ok {
+		return -1
+	}
+	return route.Len()
+}
+
+// Discards entries for rooms that no longer exist, or have changed. Route
+// does this itself whenever any room has changed since its last lookup.
+func (c *DoorCache) Purge() {
+	c.m.Lock()
+	c.purge()
+	c.m.Unlock()
+}
+
+// Like Purge, but c.m must be held
+func (c *DoorCache) purge() {
+	for rid, rr := range c.rooms {
+		if c.w.Rooms[rid] == nil || rr.gen != c.w.RoomGeneration(rid) {
+			delete(c.rooms, rid)
+		}
+	}
+}
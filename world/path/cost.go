@@ -0,0 +1,142 @@
+// Pathfinding over tiles of varying cost
+//
+// A cost layer gives the cost of stepping onto each tile, in units of
+// 1/COST_UNIT of an ordinary step. Tiles left at 0 cost COST_UNIT, so a
+// layer can make main concourses cheaper to walk, and crowded or staff only
+// tiles dearer. The World's shared cost layer is returned by Costs.
+//
+// Jump point pruning is only valid when every tile costs the same, so routes
+// over a cost layer are planned by plain A* over tiles, see weighted(..), and
+// the Routes between Doors aren't cached. Planning falls back to jps(..), HPA*
+// and the DoorCache when the cost layer is empty.
+
+package path
+
+import (
+	"container/heap"
+	"jds/game"
+	"jds/game/layer"
+	"jds/game/world"
+)
+
+const (
+	COST_UNIT = 16
+	// Costs below MIN_COST are raised to MIN_COST, so that the A* heuristic
+	// of MIN_COST per tile never overestimates
+	MIN_COST = COST_UNIT / 4
+)
+
+// Returns the cost layer of World w, see NewWeightedRoute
+func Costs(w *world.World) *layer.Layer {
+	return w.CustomLayer("PathCost")
+}
+
+// Returns the cost of stepping onto l
+func tileCost(cost *layer.Layer, l game.Location) int {
+	c := int(cost.Get(l))
+	if c == 0 {
+		return COST_UNIT
+	}
+	if c < MIN_COST {
+		return MIN_COST
+	}
+	return c
+}
+
+// Returns the cost of following r from start, in units of 1/COST_UNIT of a
+// step. If cost is nil every step costs COST_UNIT.
+func (r Route) Cost(start game.Location, cost *layer.Layer) (c int) {
+	if cost == nil {
+		return r.Len() * COST_UNIT
+	}
+	l := start
+	for _, rs := range r {
+		for i := uint(0); i < rs.Length; i++ {
+			l = l.JustStep(rs.D)
+			c += tileCost(cost, l)
+		}
+	}
+	return
+}
+
+type costItem struct {
+	l game.Location
+	g int // cost from start to l
+	w int // g plus estimated remaining cost
+}
+
+type costHeap []costItem
+
+func (h costHeap) Less(i, j int) bool {
+	if h[i].w == h[j].w {
+		// prefer items closer to the finish
+		return h[i].g > h[j].g
+	}
+	return h[i].w < h[j].w
+}
+
+func (h costHeap) Len() int {
+	return len(h)
+}
+
+func (h *costHeap) Pop() (v interface{}) {
+	v, *h = (*h)[len(*h)-1], (*h)[:len(*h)-1]
+	return
+}
+
+func (h *costHeap) Push(v interface{}) {
+	*h = append(*h, v.(costItem))
+}
+
+func (h costHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+// A* over tiles, costed by cost
+//
+// start and finish must be distinct Locations in the same room
+func weighted(w *world.World, start, finish game.Location, cost *layer.Layer) (route Route) {
+	// g+1 of each tile reached, and the Direction+1 it was reached in
+	gScore := layer.NewLayer()
+	defer gScore.Discard()
+	from := layer.NewLayer()
+	defer from.Discard()
+	h := &costHeap{{start, 0, start.MaxDistance(finish) * MIN_COST}}
+	gScore.Set(start, 1)
+	for h.Len() > 0 {
+		it := heap.Pop(h).(costItem)
+		if it.l == finish {
+			break
+		}
+		if int(gScore.Get(it.l))-1 != it.g {
+			// stale heap entry
+			continue
+		}
+		for d, n := range it.l.Neighborhood() {
+			if w.Walls.Get(n) != 0 {
+				continue
+			}
+			g := it.g + tileCost(cost, n)
+			if v := gScore.Get(n); v != 0 && int(v)-1 <= g {
+				continue
+			}
+			gScore.Set(n, game.TileId(g+1))
+			from.Set(n, game.TileId(d+1))
+			heap.Push(h, costItem{n, g, g + n.MaxDistance(finish)*MIN_COST})
+		}
+	}
+	if from.Get(finish) == 0 {
+		return nil
+	}
+	// collect steps from finish back to start
+	var steps []game.Direction
+	for l := finish; l != start; {
+		d := game.Direction(from.Get(l) - 1)
+		steps = append(steps, d)
+		l = l.JustStep(d.Reverse())
+	}
+	for i := len(steps) - 1; i >= 0; i-- {
+		route = route.join(Route{{Length: 1, D: steps[i]}})
+	}
+	return
+}
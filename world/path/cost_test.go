@@ -0,0 +1,89 @@
+package path
+
+import (
+	"jds/game"
+	"jds/game/layer"
+	"jds/game/world"
+	"testing"
+)
+
+// Sets the cost of the tiles in the box from ul to lr, inclusive
+func costBox(cost *layer.Layer, ul, lr game.Location, c int) {
+	for l := range game.Box(ul, lr) {
+		cost.Set(l, game.TileId(c))
+	}
+}
+
+// Walks route r from start like walkRoute, failing if it steps onto a tile
+// costing more than COST_UNIT
+func walkCheap(t *testing.T, w *world.World, start game.Location, r Route, cost *layer.Layer) game.Location {
+	l := start
+	for _, rs := range r {
+		for i := uint(0); i < rs.Length; i++ {
+			l = l.JustStep(rs.D)
+			if tileCost(cost, l) > COST_UNIT {
+				t.Fatal("route steps onto costly tile at", l)
+			}
+		}
+	}
+	return walkRoute(t, w, start, r)
+}
+
+func TestWeightedRoute(t *testing.T) {
+	w := world.NewWorld(0)
+	defer w.Discard()
+	ul := game.Location{}
+	w.DrawBox(ul, ul.JustOffset(40, 20))
+	start, finish := ul.JustOffset(2, 10), ul.JustOffset(37, 10)
+	cost := layer.NewLayer()
+	defer cost.Discard()
+	// An empty cost layer is ignored
+	if r := NewWeightedRoute(w, start, finish, cost); r.Len() != NewRoute(w, start, finish).Len() {
+		t.Fatal("wrong route over empty cost layer:", r)
+	}
+	// A staff only area across the direct route is walked around, along a
+	// cheap concourse by the top wall
+	costBox(cost, ul.JustOffset(10, 3), ul.JustOffset(30, 19), 20*COST_UNIT)
+	costBox(cost, ul.JustOffset(1, 1), ul.JustOffset(39, 1), MIN_COST)
+	r := NewWeightedRoute(w, start, finish, cost)
+	if end := walkCheap(t, w, start, r, cost); end != finish {
+		t.Fatal("weighted route ends at", end, "not", finish)
+	}
+	plain := NewRoute(w, start, finish)
+	if r.Cost(start, cost) >= plain.Cost(start, cost) || plain.Cost(start, nil) != plain.Len()*COST_UNIT {
+		t.Error("weighted route isn't cheaper:", r.Cost(start, cost), plain.Cost(start, cost))
+	}
+	if r.Cost(start, cost) > 25*COST_UNIT {
+		t.Error("weighted route doesn't follow the concourse:", r.Cost(start, cost))
+	}
+	// Costs below MIN_COST are raised to it
+	cost.Set(ul.JustOffset(5, 1), 1)
+	if tileCost(cost, ul.JustOffset(5, 1)) != MIN_COST || tileCost(cost, ul.JustOffset(5, 5)) != COST_UNIT {
+		t.Error("wrong tile costs")
+	}
+}
+
+func TestWeightedDoorRoute(t *testing.T) {
+	w := world.NewWorld(0)
+	defer w.Discard()
+	ul := game.Location{}
+	w.DrawBox(ul, ul.JustOffset(20, 20))
+	w.DrawBox(ul.JustOffset(20, 0), ul.JustOffset(40, 20))
+	for _, y := range []int{3, 15} {
+		if w.NewDoor(ul.JustOffset(19, y), world.VERT, nil) == nil {
+			t.Fatal("couldn't place door")
+		}
+	}
+	start, finish := ul.JustOffset(5, 4), ul.JustOffset(35, 4)
+	cost := Costs(w)
+	// The area behind the near door is costly, so the far door is taken
+	costBox(cost, ul.JustOffset(20, 1), ul.JustOffset(33, 9), 20*COST_UNIT)
+	r := NewWeightedRoute(w, start, finish, cost)
+	if end := walkCheap(t, w, start, r, cost); end != finish {
+		t.Fatal("weighted route ends at", end, "not", finish)
+	}
+	// Routes that don't use the cost layer still take the near door
+	if NewRoute(w, start, finish).Len() >= r.Len() {
+		t.Error("unweighted route doesn't take the near door")
+	}
+}
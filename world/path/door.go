@@ -0,0 +1,218 @@
+// Pathfinding between rooms
+//
+// The rooms of a World and the Doors joining them form a graph. Routes
+// between rooms are planned by A* over this graph, with the legs inside each
+// room filled in by roomRoute(..)
+
+package path
+
+import (
+	"container/heap"
+	"jds/game"
+	"jds/game/layer"
+	"jds/game/world"
+)
+
+// A doorNode is a node in the room/door graph. It is the tile that is
+// stepped onto after crossing Door D into the room on side S, or the route's
+// finish if D is nil.
+type doorNode struct {
+	D *world.Door
+	S int // index into D.DoorSteps() and D.R
+}
+
+// Location of the tile n represents
+func (n doorNode) location(finish game.Location) game.Location {
+	if n.D == nil {
+		return finish
+	}
+	return n.D.DoorSteps()[n.S]
+}
+
+// Room entered at n
+func (n doorNode) room() world.RoomId {
+	return n.D.R[n.S]
+}
+
+type doorWalker struct {
+	N   doorNode
+	G   int // route cost from start to N
+	W   int // G plus estimated remaining cost
+	P   *doorWalker
+	Leg Route // route from P's tile to N's tile
+}
+
+type doorWalkerHeap struct {
+	l []*doorWalker
+}
+
+func (h *doorWalkerHeap) Less(i, j int) bool {
+	return h.l[i].W < h.l[j].W
+}
+
+func (h *doorWalkerHeap) Len() int {
+	return len(h.l)
+}
+
+func (h *doorWalkerHeap) Pop() (v interface{}) {
+	v, h.l = h.l[len(h.l)-1], h.l[:len(h.l)-1]
+	return
+}
+
+func (h *doorWalkerHeap) Push(v interface{}) {
+	h.l = append(h.l, v.(*doorWalker))
+}
+
+func (h *doorWalkerHeap) Swap(i, j int) {
+	h.l[i], h.l[j] = h.l[j], h.l[i]
+}
+
+// Returns the Route to cross Door d, starting on side 'from'
+func crossDoor(d *world.Door, from int) Route {
+	dir := game.Direction(game.RIGHT)
+	if d.O == world.HORZ {
+		dir = game.DOWN
+	}
+	if from == 1 {
+		dir = dir.Reverse()
+	}
+	return Route{{Length: 2, D: dir}}
+}
+
+// Returns a Route from a to b inside a single room. ok is false if there is
+// no such Route. Long routes are planned by HPA*, see ClusterGraph. If cost
+// isn't nil, the cheapest Route is planned by weighted(..) instead.
+func roomRoute(w *world.World, a, b game.Location, cost *layer.Layer) (route Route, ok bool) {
+	if a == b {
+		return nil, true
+	}
+	if cost != nil {
+		route = weighted(w, a, b, cost)
+		return route, route != nil
+	}
+	if a.MaxDistance(b) >= HPA_MIN_DISTANCE {
+		if route, ok = Clusters(w).Route(a, b); ok {
+			return
+		}
+	}
+	route = jps(w, a, b)
+	return route, route != nil
+}
+
+// Appends the segments of r to route, merging segments where the direction
+// does not change
+func (route Route) join(r Route) Route {
+	for _, rs := range r {
+		if last := len(route) - 1; last >= 0 && route[last].D == rs.D {
+			route[last].Length += rs.Length
+			continue
+		}
+		route = append(route, rs)
+	}
+	return route
+}
+
+// A* over the room/door graph from start, in room startRid, to finish, in
+// room finishRid. The cost of an edge is the Cost of the roomRoute(..)
+// across the room, plus that of the door crossing.
+func doorRoute(w *world.World, start, finish game.Location, startRid, finishRid world.RoomId, cost *layer.Layer) (route Route) {
+	type nodeKey struct {
+		Did world.DoorId
+		S   int
+	}
+	gScore := make(map[nodeKey]int)
+	closedSet := make(map[nodeKey]bool)
+	openSet := new(doorWalkerHeap)
+	cache := Cache(w)
+	// cheapest possible cost of a step, for the heuristic
+	unit := COST_UNIT
+	if cost != nil {
+		unit = MIN_COST
+	}
+	// expand pushes the neighbors of the tile at 'from', in room rid
+	expand := func(current *doorWalker, from game.Location, rid world.RoomId) {
+		r := w.Rooms[rid]
+		if r == nil {
+			return
+		}
+		if rid == finishRid {
+			if leg, ok := roomRoute(w, from, finish, cost); ok {
+				g := current.G + leg.Cost(from, cost)
+				heap.Push(openSet, &doorWalker{
+					G:   g,
+					W:   g,
+					P:   current,
+					Leg: leg,
+				})
+			}
+		}
+		for _, did := range r.DoorIds {
+			d := w.Doors[did]
+			for i := range d.R {
+				other := 1 - i
+				if d.R[i] != rid || d.R[other] == world.ROOMID_INVALID || d.R[other] == rid {
+					// d doesn't lead out of rid on this side
+					continue
+				}
+				if !d.Routable(i) {
+					// d is closed, or one-way the other way
+					continue
+				}
+				key := nodeKey{d.Id, other}
+				if closedSet[key] {
+					continue
+				}
+				var leg Route
+				var ok bool
+				if current.N.D != nil && cost == nil {
+					// door to door routes are cached
+					leg, ok = cache.Route(rid, current.N.D, d)
+				} else {
+					leg, ok = roomRoute(w, from, d.DoorSteps()[i], cost)
+				}
+				if !ok {
+					continue
+				}
+				// copy leg, as it may be shared with the cache
+				leg = Route(nil).join(leg).join(crossDoor(d, i))
+				g := current.G + leg.Cost(from, cost)
+				if best, seen := gScore[key]; seen && best <= g {
+					continue
+				}
+				gScore[key] = g
+				n := doorNode{d, other}
+				heap.Push(openSet, &doorWalker{
+					N:   n,
+					G:   g,
+					W:   g + n.location(finish).MaxDistance(finish)*unit,
+					P:   current,
+					Leg: leg,
+				})
+			}
+		}
+	}
+	expand(&doorWalker{}, start, startRid)
+	for openSet.Len() > 0 {
+		current := heap.Pop(openSet).(*doorWalker)
+		if current.N.D == nil {
+			// reached finish, collect legs from finish back to start
+			legs := make([]Route, 0)
+			for n := current; n.P != nil; n = n.P {
+				legs = append(legs, n.Leg)
+			}
+			route = make(Route, 0, len(legs))
+			for i := len(legs) - 1; i >= 0; i-- {
+				route = route.join(legs[i])
+			}
+			return
+		}
+		key := nodeKey{current.N.D.Id, current.N.S}
+		if closedSet[key] || gScore[key] < current.G {
+			// stale heap entry
+			continue
+		}
+		closedSet[key] = true
+		expand(current, current.N.location(finish), current.N.room())
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package path
+
+import (
+	"jds/game"
+	"jds/game/world"
+	"testing"
+)
+
+// Builds a row of n 10x10 rooms, each joined to the next by a Door
+func doorRow(t testing.TB, n int) (w *world.World, origin game.Location) {
+	w = world.NewWorld(0)
+	for i := 0; i < n; i++ {
+		w.DrawBox(origin.JustOffset(10*i, 0), origin.JustOffset(10*i+10, 10))
+	}
+	for i := 1; i < n; i++ {
+		if w.NewDoor(origin.JustOffset(10*i-1, 3), world.VERT, nil) == nil {
+			t.Fatal("couldn't place door")
+		}
+	}
+	return
+}
+
+// Walks route r from start, returning the finish Location. Fails if the
+// route passes through a wall that isn't under a door.
+func walkRoute(t *testing.T, w *world.World, start game.Location, r Route) game.Location {
+	for _, rs := range r {
+		for i := uint(0); i < rs.Length; i++ {
+			start = start.JustStep(rs.D)
+			if w.Walls.Get(start) != 0 && w.DoorIds.Get(start) == 0 {
+				t.Fatal("route goes through wall at", start)
+			}
+		}
+	}
+	return start
+}
+
+func TestRouteThroughDoors(t *testing.T) {
+	w, origin := doorRow(t, 4)
+	start := origin.JustOffset(2, 8)
+	finish := origin.JustOffset(38, 1)
+	r := NewRoute(w, start, finish)
+	if r == nil {
+		t.Fatal("no route found")
+	}
+	if end := walkRoute(t, w, start, r); end != finish {
+		t.Errorf("didn't arrive at destination. got %v want %v", end, finish)
+	}
+	// and back again
+	r = NewRoute(w, finish, start)
+	if end := walkRoute(t, w, finish, r); end != start {
+		t.Errorf("didn't arrive at start. got %v want %v", end, start)
+	}
+}
+
+func TestRouteNoDoor(t *testing.T) {
+	w, origin := doorRow(t, 1)
+	w.DrawBox(origin.JustOffset(20, 0), origin.JustOffset(30, 10))
+	if r := NewRoute(w, origin.JustOffset(2, 2), origin.JustOffset(22, 2)); r != nil {
+		t.Error("found route between rooms without a door")
+	}
+}
+
+func TestDoorCacheInvalidation(t *testing.T) {
+	w, origin := doorRow(t, 4)
+	start := origin.JustOffset(2, 3)
+	finish := origin.JustOffset(38, 3)
+	c := Cache(w)
+	NewRoute(w, start, finish)
+	misses := c.Misses
+	r := NewRoute(w, start, finish)
+	if c.Hits == 0 || c.Misses != misses {
+		t.Errorf("expected only cache hits, got %d hits %d misses", c.Hits, c.Misses-misses)
+	}
+	if end := walkRoute(t, w, start, r); end != finish {
+		t.Errorf("didn't arrive at destination. got %v want %v", end, finish)
+	}
+	// Block the straight line between the doors of the second room. Cached
+	// routes for that room must not be used.
+	w.DrawLine(origin.JustOffset(15, 1), origin.JustOffset(15, 5))
+	r = NewRoute(w, start, finish)
+	if c.Misses == misses {
+		t.Error("cache wasn't invalidated by wall change")
+	}
+	if end := walkRoute(t, w, start, r); end != finish {
+		t.Errorf("didn't arrive at destination. got %v want %v", end, finish)
+	}
+	// Divide the third room, then look up a route in the second. The third
+	// room's entries are stale and must be discarded without a Purge.
+	rid := world.RoomId(w.RoomIds.Get(origin.JustOffset(15, 3)))
+	if c.rooms[world.RoomId(w.RoomIds.Get(origin.JustOffset(25, 3)))] == nil {
+		t.Fatal("third room wasn't cached")
+	}
+	w.DrawLine(origin.JustOffset(25, 0), origin.JustOffset(25, 10))
+	d1 := w.Doors[world.DoorId(w.DoorIds.Get(origin.JustOffset(9, 3)))]
+	d2 := w.Doors[world.DoorId(w.DoorIds.Get(origin.JustOffset(19, 3)))]
+	c.Route(rid, d1, d2)
+	for rid, rr := range c.rooms {
+		if rr.gen != w.RoomGeneration(rid) {
+			t.Error("lookup kept stale room", rid)
+		}
+	}
+}
+
+func TestRouteDoorStates(t *testing.T) {
+	w, origin := doorRow(t, 3)
+	start := origin.JustOffset(2, 3)
+	finish := origin.JustOffset(28, 3)
+	did := world.DoorId(w.DoorIds.Get(origin.JustOffset(19, 3)))
+	for _, c := range []struct {
+		s           world.DoorState
+		there, back bool
+	}{
+		{world.DOOR_LOCKED, false, false},
+		{world.DOOR_CLOSED, false, false},
+		{world.DOOR_ONEWAY, true, false},
+		{world.DOOR_OPEN, true, true},
+	} {
+		w.SetDoorState(did, c.s)
+		if r := NewRoute(w, start, finish); (r != nil) != c.there {
+			t.Errorf("%v door: route there %v", c.s, r)
+		} else if r != nil && walkRoute(t, w, start, r) != finish {
+			t.Errorf("%v door: didn't arrive at destination", c.s)
+		}
+		if r := NewRoute(w, finish, start); (r != nil) != c.back {
+			t.Errorf("%v door: route back %v", c.s, r)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+// Flow fields
+//
+// A FlowField gives each tile from which its target can be reached the
+// direction of the first step of a shortest route to the target. It is found
+// by a single search outwards from the target, and shared by all the entities
+// headed there, so a crowd with one destination costs no more than a single
+// walker. Routes may pass through Doors, whose State is respected.
+//
+// A FlowField is brought up to date when it is next used after walls or
+// Doors change. Only tiles whose route ran through a changed block, and tiles
+// that may now have a shorter route, are searched again, except that a
+// FlowField towards a room is searched again in full when the room changes.
+
+package path
+
+import (
+	"container/heap"
+	"jds/game"
+	"jds/game/layer"
+	"jds/game/world"
+	"sync"
+)
+
+type FlowTargetKind int8
+
+const (
+	FLOW_LOCATION FlowTargetKind = iota
+	FLOW_DOOR
+	FLOW_ROOM
+)
+
+// The destination of a FlowField. Only the field for its Kind is used.
+type FlowTarget struct {
+	Kind FlowTargetKind
+	L    game.Location
+	Door world.DoorId
+	Room world.RoomId
+}
+
+// A FlowTarget of the single tile l
+func ToLocation(l game.Location) FlowTarget {
+	return FlowTarget{Kind: FLOW_LOCATION, L: l}
+}
+
+// A FlowTarget of the DoorSteps of Door did, on either side
+func ToDoor(did world.DoorId) FlowTarget {
+	return FlowTarget{Kind: FLOW_DOOR, Door: did}
+}
+
+// A FlowTarget of every tile of room rid
+func ToRoom(rid world.RoomId) FlowTarget {
+	return FlowTarget{Kind: FLOW_ROOM, Room: rid}
+}
+
+// Directions towards a FlowTarget. Safe for concurrent use during Think.
+type FlowField struct {
+	w      *world.World
+	ff     *FlowFields
+	target FlowTarget
+	m      sync.RWMutex
+	// Distance to the target plus one, and the Direction of the next step
+	// plus one, of each tile. 0 where the target can't be reached.
+	dist, dir *layer.Layer
+	built     bool
+	// World generations when the field was last updated
+	wallGen, gen, roomGen uint64
+	// The Doors when the field was last updated
+	doors map[world.DoorId]flowDoor
+	// Number of users, guarded by ff.m
+	refs int
+	// Number of full searches, and of partial searches after changes
+	Rebuilds, Updates int
+}
+
+type flowDoor struct {
+	L     game.Location
+	State world.DoorState
+}
+
+// The FlowFields of a World, shared by the entities using them
+type FlowFields struct {
+	w      *world.World
+	m      sync.Mutex
+	fields map[FlowTarget]*FlowField
+}
+
+// Returns the FlowFields of World w, creating them if needed
+func Flows(w *world.World) *FlowFields {
+	return w.CustomData("path.FlowFields", func() interface{} {
+		return &FlowFields{
+			w:      w,
+			fields: make(map[FlowTarget]*FlowField),
+		}
+	}).(*FlowFields)
+}
+
+// Returns the FlowField towards t, creating it if no one is using it. Each
+// call must be matched by a call to Release.
+func (ff *FlowFields) Acquire(t FlowTarget) *FlowField {
+	ff.m.Lock()
+	defer ff.m.Unlock()
+	f := ff.fields[t]
+	if f == nil {
+		f = &FlowField{
+			w:      ff.w,
+			ff:     ff,
+			target: t,
+			dist:   layer.NewLayer(),
+			dir:    layer.NewLayer(),
+		}
+		ff.fields[t] = f
+	}
+	f.refs++
+	return f
+}
+
+// Returns the number of FlowFields in use
+func (ff *FlowFields) Len() int {
+	ff.m.Lock()
+	defer ff.m.Unlock()
+	return len(ff.fields)
+}
+
+// Stops using f. f is discarded once it has no users, and must not be used
+// after it is released.
+func (f *FlowField) Release() {
+	ff := f.ff
+	ff.m.Lock()
+	defer ff.m.Unlock()
+	if f.refs--; f.refs > 0 {
+		return
+	}
+	delete(ff.fields, f.target)
+	f.m.Lock()
+	f.dist.Discard()
+	f.dir.Discard()
+	f.m.Unlock()
+}
+
+func (f *FlowField) Target() FlowTarget {
+	return f.target
+}
+
+// Returns the Direction of the next step from l towards the target, or NONE
+// if l is part of the target. ok is false if the target can't be reached
+// from l.
+func (f *FlowField) Step(l game.Location) (d game.Direction, ok bool) {
+	f.read()
+	v := f.dir.Get(l)
+	f.m.RUnlock()
+	return game.Direction(v - 1), v != 0
+}
+
+// Returns the length of the shortest route from l to the target, or -1 if
+// there is none
+func (f *FlowField) Distance(l game.Location) int {
+	f.read()
+	v := f.dist.Get(l)
+	f.m.RUnlock()
+	return int(v) - 1
+}
+
+// Returns the route from l to the target, following the field, or nil if
+// there is none
+func (f *FlowField) Route(l game.Location) (route Route) {
+	f.read()
+	defer f.m.RUnlock()
+	for {
+		v := f.dir.Get(l)
+		if v == 0 || game.Direction(v-1) == game.NONE {
+			return
+		}
+		d := game.Direction(v - 1)
+		route = route.join(Route{{Length: 1, D: d}})
+		l = l.JustStep(d)
+	}
+}
+
+// Read locks f, first updating it if the World has changed
+func (f *FlowField) read() {
+	f.m.RLock()
+	if !f.stale() {
+		return
+	}
+	f.m.RUnlock()
+	f.m.Lock()
+	if f.stale() {
+		f.update()
+	}
+	f.m.Unlock()
+	f.m.RLock()
+}
+
+// Returns true if walls or rooms have changed since f was updated
+func (f *FlowField) stale() bool {
+	return !f.built || f.wallGen != f.w.WallGeneration() || f.gen != f.w.Generation()
+}
+
+// Brings f up to date with the World
+func (f *FlowField) update() {
+	w := f.w
+	if !f.built || f.target.Kind == FLOW_ROOM && f.roomGen != w.RoomGeneration(f.target.Room) {
+		f.rebuild()
+	} else {
+		changed := w.ChangedBlocks(f.wallGen)
+		// Doors that were placed, removed or changed State
+		addDoor := func(l game.Location) {
+			for _, corner := range []game.Location{l, l.JustOffset(3, 0), l.JustOffset(0, 3), l.JustOffset(3, 3)} {
+				changed.AddLocation(corner)
+			}
+		}
+		for did, d := range w.Doors {
+			if fd, ok := f.doors[did]; !ok || fd.State != d.State {
+				addDoor(d.L)
+			}
+		}
+		for did, fd := range f.doors {
+			if w.Doors[did] == nil {
+				addDoor(fd.L)
+			}
+		}
+		f.repair(changed)
+	}
+	f.built = true
+	f.wallGen, f.gen = w.WallGeneration(), w.Generation()
+	f.roomGen = w.RoomGeneration(f.target.Room)
+	f.doors = make(map[world.DoorId]flowDoor, len(w.Doors))
+	for did, d := range w.Doors {
+		f.doors[did] = flowDoor{d.L, d.State}
+	}
+}
+
+// Returns the tiles of f's target
+func (f *FlowField) targets() (ls []game.Location) {
+	w := f.w
+	switch f.target.Kind {
+	case FLOW_LOCATION:
+		ls = append(ls, f.target.L)
+	case FLOW_DOOR:
+		if d := w.Doors[f.target.Door]; d != nil {
+			steps := d.DoorSteps()
+			ls = append(ls, steps[:]...)
+		}
+	case FLOW_ROOM:
+		r := w.Rooms[f.target.Room]
+		if r == nil {
+			return
+		}
+		r.Interior(func(rm *game.RowMask) bool {
+			for i := 0; i < rm.Width(); {
+				in, run := rm.Mask(i)
+				if in {
+					for j := 0; j < run; j++ {
+						ls = append(ls, rm.Left.JustOffset(i+j, 0))
+					}
+				}
+				i += run
+			}
+			return true
+		})
+	}
+	return
+}
+
+// Returns true if an entity can stand on l
+func (f *FlowField) standable(l game.Location) bool {
+	w := f.w
+	if w.Walls.Get(l) == 0 {
+		return w.RoomIds.Get(l) != 0
+	}
+	return w.Doors[world.DoorId(w.DoorIds.Get(l))] != nil
+}
+
+// Returns true if an entity can step onto l in direction d, as in
+// World.StepEntity
+func (f *FlowField) enterable(l game.Location, d game.Direction) bool {
+	w := f.w
+	if w.Walls.Get(l) == 0 {
+		return w.RoomIds.Get(l) != 0
+	}
+	door := w.Doors[world.DoorId(w.DoorIds.Get(l))]
+	return door != nil && door.Passable(d)
+}
+
+// Returns true if the distance and direction of reached tile l still hold,
+// assuming those of the tile it steps to do
+func (f *FlowField) valid(l game.Location) bool {
+	if !f.standable(l) {
+		return false
+	}
+	d := game.Direction(f.dir.Get(l) - 1)
+	return d == game.NONE || f.enterable(l.JustStep(d), d)
+}
+
+// Searches from the target again
+func (f *FlowField) rebuild() {
+	f.Rebuilds++
+	f.dist.Discard()
+	f.dir.Discard()
+	h := new(flowHeap)
+	f.seedTargets(h)
+	f.spread(h)
+}
+
+// Adds the tiles of the target that aren't reached to h
+func (f *FlowField) seedTargets(h *flowHeap) {
+	for _, l := range f.targets() {
+		if f.dist.Get(l) == 0 && f.standable(l) {
+			f.dist.Set(l, 1)
+			f.dir.Set(l, game.TileId(game.NONE)+1)
+			heap.Push(h, flowItem{l, 0})
+		}
+	}
+}
+
+// Searches again from the tiles around the blocks in 'changed'
+func (f *FlowField) repair(changed game.ModMap) {
+	f.Updates++
+	var invalid []game.Location
+	clear := func(l game.Location) {
+		if f.dist.Get(l) != 0 {
+			f.dist.Set(l, 0)
+			f.dir.Set(l, 0)
+			invalid = append(invalid, l)
+		}
+	}
+	// Tiles in or next to a changed block whose step is no longer possible
+	for bid := range changed {
+		for i := 0; i < blockTiles; i++ {
+			l := tileLocation(bid, i)
+			if f.dist.Get(l) != 0 && !f.valid(l) {
+				clear(l)
+			}
+			for _, n := range l.Neighborhood() {
+				if n.BlockId != bid && f.dist.Get(n) != 0 && !f.valid(n) {
+					clear(n)
+				}
+			}
+		}
+	}
+	// and the tiles whose route passed through them
+	for i := 0; i < len(invalid); i++ {
+		u := invalid[i]
+		for _, n := range u.Neighborhood() {
+			if v := f.dir.Get(n); v != 0 && n.JustStep(game.Direction(v-1)) == u {
+				clear(n)
+			}
+		}
+	}
+	// Search again from the reached tiles around the cleared tiles and in
+	// the changed blocks, which may now lead to unreached tiles
+	h := new(flowHeap)
+	seed := func(l game.Location) {
+		if v := f.dist.Get(l); v != 0 {
+			heap.Push(h, flowItem{l, int(v) - 1})
+		}
+	}
+	for _, u := range invalid {
+		for _, n := range u.Neighborhood() {
+			seed(n)
+		}
+	}
+	for bid := range changed {
+		for i := 0; i < blockTiles; i++ {
+			l := tileLocation(bid, i)
+			seed(l)
+			for _, n := range l.Neighborhood() {
+				if n.BlockId != bid {
+					seed(n)
+				}
+			}
+		}
+	}
+	f.seedTargets(h)
+	f.spread(h)
+}
+
+// Dijkstra's algorithm from the tiles in h, whose distances are set
+func (f *FlowField) spread(h *flowHeap) {
+	for h.Len() > 0 {
+		it := heap.Pop(h).(flowItem)
+		if int(f.dist.Get(it.l))-1 != it.d {
+			// stale heap entry
+			continue
+		}
+		for d, n := range it.l.Neighborhood() {
+			step := game.Direction(d).Reverse()
+			if v := f.dist.Get(n); v != 0 && int(v)-1 <= it.d+1 {
+				continue
+			}
+			if !f.standable(n) || !f.enterable(it.l, step) {
+				continue
+			}
+			f.dist.Set(n, game.TileId(it.d+2))
+			f.dir.Set(n, game.TileId(step)+1)
+			heap.Push(h, flowItem{n, it.d + 1})
+		}
+	}
+}
+
+type flowItem struct {
+	l game.Location
+	d int // distance to the target
+}
+
+type flowHeap struct {
+	l []flowItem
+}
+
+func (h *flowHeap) Less(i, j int) bool {
+	return h.l[i].d < h.l[j].d
+}
+
+func (h *flowHeap) Len() int {
+	return len(h.l)
+}
+
+func (h *flowHeap) Pop() (v interface{}) {
+	v, h.l = h.l[len(h.l)-1], h.l[:len(h.l)-1]
+	return
+}
+
+func (h *flowHeap) Push(v interface{}) {
+	h.l = append(h.l, v.(flowItem))
+}
+
+func (h *flowHeap) Swap(i, j int) {
+	h.l[i], h.l[j] = h.l[j], h.l[i]
+}
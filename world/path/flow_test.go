@@ -0,0 +1,97 @@
+package path
+
+import (
+	"jds/game"
+	"jds/game/world"
+	"testing"
+)
+
+// Fails unless the FlowField f leads from every tile of the rooms of w
+// between ul and lr to the target, by Routes of length Distance
+func checkFlow(t *testing.T, w *world.World, f *FlowField, ul, lr game.Location, reachable bool) {
+	dx, dy := ul.Distance(lr)
+	for x := 0; x <= int(dx); x++ {
+		for y := 0; y <= int(dy); y++ {
+			l := ul.JustOffset(x, y)
+			if w.RoomIds.Get(l) == 0 {
+				continue
+			}
+			dist := f.Distance(l)
+			if (dist >= 0) != reachable {
+				t.Fatal("wrong reachability at", l, dist)
+			}
+			if dist < 0 {
+				continue
+			}
+			r := f.Route(l)
+			end := walkRoute(t, w, l, r)
+			if r.Len() != dist {
+				t.Fatal("route from", l, "has length", r.Len(), "not", dist)
+			}
+			if d, _ := f.Step(end); d != game.NONE {
+				t.Fatal("route from", l, "ends at", end, "outside the target")
+			}
+		}
+	}
+}
+
+func TestFlowField(t *testing.T) {
+	w, origin := doorRow(t, 4)
+	defer w.Discard()
+	finish := origin.JustOffset(38, 8)
+	ff := Flows(w)
+	f := ff.Acquire(ToLocation(finish))
+	if ff.Acquire(ToLocation(finish)) != f {
+		t.Fatal("FlowField wasn't shared")
+	}
+	f.Release()
+	checkFlow(t, w, f, origin, origin.JustOffset(40, 10), true)
+	// Distances are those of the shortest routes
+	if d := f.Distance(origin.JustOffset(31, 8)); d != 7 {
+		t.Error("wrong distance in target room:", d)
+	}
+	// A new wall only searches again around the wall
+	w.DrawLine(origin.JustOffset(25, 0), origin.JustOffset(25, 7))
+	checkFlow(t, w, f, origin, origin.JustOffset(40, 10), true)
+	if f.Rebuilds != 1 || f.Updates != 1 {
+		t.Error("wrong number of searches:", f.Rebuilds, f.Updates)
+	}
+	updated := make(map[game.Location]int)
+	for x := 0; x <= 40; x++ {
+		for y := 0; y <= 10; y++ {
+			updated[origin.JustOffset(x, y)] = f.Distance(origin.JustOffset(x, y))
+		}
+	}
+	f.Release()
+	if ff.Len() != 0 {
+		t.Fatal("released FlowField wasn't discarded")
+	}
+	f = ff.Acquire(ToLocation(finish))
+	defer f.Release()
+	for l, dist := range updated {
+		if d := f.Distance(l); d != dist {
+			t.Fatal("updated distance at", l, dist, "differs from new FlowField's", d)
+		}
+	}
+	// Doors change which rooms can reach the target
+	did := world.DoorId(w.DoorIds.Get(origin.JustOffset(29, 3)))
+	w.SetDoorState(did, world.DOOR_LOCKED)
+	checkFlow(t, w, f, origin, origin.JustOffset(29, 10), false)
+	checkFlow(t, w, f, origin.JustOffset(30, 0), origin.JustOffset(40, 10), true)
+	w.SetDoorState(did, world.DOOR_OPEN)
+	checkFlow(t, w, f, origin, origin.JustOffset(40, 10), true)
+	// Other targets
+	door := ff.Acquire(ToDoor(did))
+	defer door.Release()
+	checkFlow(t, w, door, origin, origin.JustOffset(40, 10), true)
+	if d := door.Distance(origin.JustOffset(35, 3)); d != 4 {
+		t.Error("wrong distance to door:", d)
+	}
+	rid := world.RoomId(w.RoomIds.Get(origin.JustOffset(15, 5)))
+	room := ff.Acquire(ToRoom(rid))
+	defer room.Release()
+	checkFlow(t, w, room, origin, origin.JustOffset(40, 10), true)
+	if d := room.Distance(origin.JustOffset(5, 5)); d != 6 {
+		t.Error("wrong distance to room:", d)
+	}
+}
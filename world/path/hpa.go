@@ -0,0 +1,488 @@
+// Hierarchical pathfinding (HPA*)
+//
+// Long routes inside a room are planned over an abstract graph rather than
+// tile by tile. Each block of the World is a cluster. Where free tiles of two
+// neighboring blocks touch, entrances are placed on the tiles either side of
+// the border, and the distances between the entrances of a block are found by
+// breadth first searches inside the block. A route is found by A* over the
+// entrances, then refined: hops inside a block by a search inside the block,
+// hops across a border by a single step, and the legs from the start and to
+// the finish by the searches of their blocks made to find the first and last
+// entrances.
+//
+// Clusters are built when first needed, and rebuilt when
+// World.BlockGeneration reports that walls in the block, or in a neighboring
+// block, may have changed.
+
+package path
+
+import (
+	"container/heap"
+	"jds/game"
+	"jds/game/world"
+	"sync"
+)
+
+const (
+	// Routes inside a room spanning fewer tiles than this are found by
+	// jps(..) alone
+	HPA_MIN_DISTANCE = 2 * game.BLOCK_SIZE
+	// Runs of free border tiles at least this long get an entrance at each
+	// end, shorter runs get one in the middle
+	hpaLongRun = 6
+)
+
+// Tiles in a block
+const blockTiles = game.BLOCK_SIZE * game.BLOCK_SIZE
+
+// Index of l in its block
+func tileIndex(l game.Location) int {
+	return int(l.Y)*game.BLOCK_SIZE + int(l.X)
+}
+
+// Location of tile i of block bid
+func tileLocation(bid game.BlockId, i int) game.Location {
+	return game.Location{
+		BlockId: bid,
+		X:       int8(i % game.BLOCK_SIZE),
+		Y:       int8(i / game.BLOCK_SIZE),
+	}
+}
+
+// The entrances of a block, and the distances between them
+type cluster struct {
+	bid game.BlockId
+	// BlockGeneration of bid and its neighbors when the cluster was built,
+	// and the WallGeneration when gens was last found to be current
+	gens    [5]uint64
+	checked uint64
+	wall    [blockTiles]bool
+	// Entrance tiles, and the index in nodes of each tile of the block, -1
+	// if it isn't an entrance
+	nodes []game.Location
+	index [blockTiles]int16
+	// Entrance tiles across the border from each node
+	cross [][]game.Location
+	// Length of the shortest route inside the block between each pair of
+	// nodes, -1 if there is none
+	dist [][]int
+	// Cached routes between pairs of nodes, guarded by m
+	m     sync.Mutex
+	route map[[2]int]Route
+}
+
+// A ClusterGraph plans routes inside rooms by HPA*. Safe for concurrent use
+// during Think.
+type ClusterGraph struct {
+	w        *world.World
+	m        sync.Mutex
+	clusters map[game.BlockId]*cluster
+	// Number of clusters built
+	Builds int
+}
+
+// Returns the ClusterGraph for World w, creating it if needed
+func Clusters(w *world.World) *ClusterGraph {
+	return w.CustomData("path.ClusterGraph", func() interface{} {
+		return &ClusterGraph{
+			w:        w,
+			clusters: make(map[game.BlockId]*cluster),
+		}
+	}).(*ClusterGraph)
+}
+
+// Returns the BlockGenerations a cluster of block bid depends on
+func (g *ClusterGraph) gens(bid game.BlockId) (gens [5]uint64) {
+	gens[0] = g.w.BlockGeneration(bid)
+	for i, nbid := range bid.Neighbors() {
+		gens[i+1] = g.w.BlockGeneration(nbid)
+	}
+	return
+}
+
+// Returns the cluster of block bid, building it if it is missing or stale
+func (g *ClusterGraph) cluster(bid game.BlockId) *cluster {
+	wallGen := g.w.WallGeneration()
+	g.m.Lock()
+	defer g.m.Unlock()
+	c := g.clusters[bid]
+	if c != nil && c.checked == wallGen {
+		return c
+	}
+	gens := g.gens(bid)
+	if c != nil && c.gens == gens {
+		c.checked = wallGen
+		return c
+	}
+	c = g.build(bid)
+	c.gens = gens
+	c.checked = wallGen
+	g.clusters[bid] = c
+	g.Builds++
+	return c
+}
+
+// Discards clusters of blocks that have changed
+func (g *ClusterGraph) Purge() {
+	g.m.Lock()
+	for bid, c := range g.clusters {
+		if c.gens != g.gens(bid) {
+			delete(g.clusters, bid)
+		}
+	}
+	g.m.Unlock()
+}
+
+// Returns tile i along the border of block bid in direction d, and the tile
+// across the border from it
+func borderTiles(bid game.BlockId, d game.Direction, i int) (in, out game.Location) {
+	last := game.BLOCK_SIZE - 1
+	switch d {
+	case game.RIGHT:
+		in = game.Location{BlockId: bid, X: int8(last), Y: int8(i)}
+	case game.LEFT:
+		in = game.Location{BlockId: bid, X: 0, Y: int8(i)}
+	case game.UP:
+		in = game.Location{BlockId: bid, X: int8(i), Y: 0}
+	case game.DOWN:
+		in = game.Location{BlockId: bid, X: int8(i), Y: int8(last)}
+	}
+	return in, in.JustStep(d)
+}
+
+func (g *ClusterGraph) build(bid game.BlockId) *cluster {
+	c := &cluster{
+		bid:   bid,
+		route: make(map[[2]int]Route),
+	}
+	for i := range c.wall {
+		c.wall[i] = g.w.Walls.Get(tileLocation(bid, i)) != 0
+		c.index[i] = -1
+	}
+	addEntrance := func(in, out game.Location) {
+		i := int(c.index[tileIndex(in)])
+		if i < 0 {
+			i = len(c.nodes)
+			c.index[tileIndex(in)] = int16(i)
+			c.nodes = append(c.nodes, in)
+			c.cross = append(c.cross, nil)
+		}
+		c.cross[i] = append(c.cross[i], out)
+	}
+	// Find the runs of free tiles along each border. The cluster across the
+	// border finds the same runs, so entrances come in pairs.
+	for _, d := range []game.Direction{game.RIGHT, game.UP, game.DOWN, game.LEFT} {
+		run := 0
+		for i := 0; i <= game.BLOCK_SIZE; i++ {
+			if i < game.BLOCK_SIZE {
+				in, out := borderTiles(bid, d, i)
+				if !c.wall[tileIndex(in)] && g.w.Walls.Get(out) == 0 {
+					run++
+					continue
+				}
+			}
+			// End of a run
+			switch {
+			case run == 0:
+			case run < hpaLongRun:
+				addEntrance(borderTiles(bid, d, i-1-run/2))
+			default:
+				addEntrance(borderTiles(bid, d, i-run))
+				addEntrance(borderTiles(bid, d, i-1))
+			}
+			run = 0
+		}
+	}
+	c.dist = make([][]int, len(c.nodes))
+	for i, n := range c.nodes {
+		dist, _ := c.search(n)
+		c.dist[i] = make([]int, len(c.nodes))
+		for j, m := range c.nodes {
+			c.dist[i][j] = dist[tileIndex(m)]
+		}
+	}
+	return c
+}
+
+// The step in tiles of each Direction, in the order of Location.Neighborhood
+var directionSteps = [8]struct{ dx, dy int }{
+	game.RIGHT:     {1, 0},
+	game.UP:        {0, -1},
+	game.DOWN:      {0, 1},
+	game.LEFT:      {-1, 0},
+	game.RIGHTUP:   {1, -1},
+	game.RIGHTDOWN: {1, 1},
+	game.LEFTUP:    {-1, -1},
+	game.LEFTDOWN:  {-1, 1},
+}
+
+// Breadth first search inside the block from l, moving in all 8 directions.
+// Returns the distance to each tile, -1 if it can't be reached, and the
+// direction each tile was entered from.
+func (c *cluster) search(l game.Location) (dist [blockTiles]int, from [blockTiles]game.Direction) {
+	for i := range dist {
+		dist[i] = -1
+	}
+	start := tileIndex(l)
+	dist[start] = 0
+	var q [blockTiles]int
+	q[0] = start
+	for head, tail := 0, 1; head < tail; head++ {
+		i := q[head]
+		x, y := i%game.BLOCK_SIZE, i/game.BLOCK_SIZE
+		for d, step := range directionSteps {
+			nx, ny := x+step.dx, y+step.dy
+			if nx < 0 || ny < 0 || nx >= game.BLOCK_SIZE || ny >= game.BLOCK_SIZE {
+				// outside the block
+				continue
+			}
+			j := ny*game.BLOCK_SIZE + nx
+			if c.wall[j] || dist[j] != -1 {
+				continue
+			}
+			dist[j] = dist[i] + 1
+			from[j] = game.Direction(d)
+			q[tail] = j
+			tail++
+		}
+	}
+	return
+}
+
+// Returns the route inside the block from a to b, which must be connected
+func (c *cluster) path(a, b game.Location) (route Route) {
+	dist, from := c.search(a)
+	if dist[tileIndex(b)] < 0 {
+		panic("no route inside block")
+	}
+	return trace(&from, a, b, dist[tileIndex(b)])
+}
+
+// Returns the route of length n from a to b found by a search from a, where
+// from is the direction each tile was entered from
+func trace(from *[blockTiles]game.Direction, a, b game.Location, n int) (route Route) {
+	steps := make([]game.Direction, n)
+	for l, i := b, n-1; l != a; i-- {
+		d := from[tileIndex(l)]
+		steps[i] = d
+		l = l.JustStep(d.Reverse())
+	}
+	for _, d := range steps {
+		route = route.join(Route{{Length: 1, D: d}})
+	}
+	return
+}
+
+// Returns the route from b to a found by a search from a, where
+// from is the direction each tile was entered from
+func traceBack(from *[blockTiles]game.Direction, a, b game.Location) (route Route) {
+	for l := b; l != a; {
+		d := from[tileIndex(l)].Reverse()
+		route = route.join(Route{{Length: 1, D: d}})
+		l = l.JustStep(d)
+	}
+	return
+}
+
+// Returns the route inside the block between nodes i and j. The returned
+// Route is shared with the cache and must not be modified.
+func (c *cluster) nodePath(i, j int) Route {
+	key := [2]int{i, j}
+	c.m.Lock()
+	route, ok := c.route[key]
+	c.m.Unlock()
+	if ok {
+		return route
+	}
+	route = c.path(c.nodes[i], c.nodes[j])
+	c.m.Lock()
+	c.route[key] = route
+	c.m.Unlock()
+	return route
+}
+
+type hpaWalker struct {
+	c *cluster
+	// Node of c, or -1 for the start and the finish
+	i int
+	// Base of c's nodes in the hpaSearch
+	base int
+	G    int // route length from start to the node
+	W    int // G plus weighted estimate of the remaining distance
+	P    *hpaWalker
+}
+
+type hpaWalkerHeap struct {
+	l []*hpaWalker
+}
+
+func (h *hpaWalkerHeap) Less(i, j int) bool {
+	if h.l[i].W != h.l[j].W {
+		return h.l[i].W < h.l[j].W
+	}
+	// Of equally promising walkers, prefer the one furthest along, as many
+	// routes through open space are equally short
+	return h.l[i].G > h.l[j].G
+}
+
+func (h *hpaWalkerHeap) Len() int {
+	return len(h.l)
+}
+
+func (h *hpaWalkerHeap) Pop() (v interface{}) {
+	v, h.l = h.l[len(h.l)-1], h.l[:len(h.l)-1]
+	return
+}
+
+func (h *hpaWalkerHeap) Push(v interface{}) {
+	h.l = append(h.l, v.(*hpaWalker))
+}
+
+func (h *hpaWalkerHeap) Swap(i, j int) {
+	h.l[i], h.l[j] = h.l[j], h.l[i]
+}
+
+// State of the nodes of the clusters visited by a search, indexed by the
+// cluster's base plus the node's index
+type hpaSearch struct {
+	g        *ClusterGraph
+	clusters map[game.BlockId]hpaCluster
+	gScore   []int
+	closed   []bool
+	// hpaWalkers are allocated from here, see walker
+	walkers []hpaWalker
+}
+
+type hpaCluster struct {
+	c    *cluster
+	base int
+}
+
+// Returns a new hpaWalker. Walkers are allocated in chunks, as a search
+// pushes hundreds of them.
+func (s *hpaSearch) walker() *hpaWalker {
+	if len(s.walkers) == cap(s.walkers) {
+		s.walkers = make([]hpaWalker, 0, 256)
+	}
+	s.walkers = s.walkers[:len(s.walkers)+1]
+	return &s.walkers[len(s.walkers)-1]
+}
+
+// Returns the cluster of block bid, and the base of its nodes
+func (s *hpaSearch) cluster(bid game.BlockId) (c *cluster, base int) {
+	hc, ok := s.clusters[bid]
+	if !ok {
+		hc = hpaCluster{s.g.cluster(bid), len(s.gScore)}
+		s.clusters[bid] = hc
+		for range hc.c.nodes {
+			s.gScore = append(s.gScore, -1)
+			s.closed = append(s.closed, false)
+		}
+	}
+	return hc.c, hc.base
+}
+
+// Returns a Route from start to finish, which must be free tiles in distinct
+// blocks. ok is false if there is no Route through the entrances of the
+// blocks.
+func (g *ClusterGraph) Route(start, finish game.Location) (route Route, ok bool) {
+	if start.BlockId == finish.BlockId {
+		return nil, false
+	}
+	// Room for the clusters of a route across a few hundred blocks, so
+	// that long searches rarely grow them
+	s := &hpaSearch{
+		g:        g,
+		clusters: make(map[game.BlockId]hpaCluster, 256),
+		gScore:   make([]int, 0, 2048),
+		closed:   make([]bool, 0, 2048),
+	}
+	cs, sbase := s.cluster(start.BlockId)
+	cf, _ := s.cluster(finish.BlockId)
+	distStart, fromStart := cs.search(start)
+	distFinish, fromFinish := cf.search(finish)
+	openSet := new(hpaWalkerHeap)
+	push := func(p *hpaWalker, c *cluster, base, i, g int) {
+		l := finish
+		if i >= 0 {
+			id := base + i
+			if best := s.gScore[id]; best >= 0 && best <= g {
+				return
+			}
+			s.gScore[id] = g
+			l = c.nodes[i]
+		}
+		// The estimate is weighted to explore fewer entrances, at the cost
+		// of routes a little longer than the shortest
+		w := s.walker()
+		*w = hpaWalker{
+			c:    c,
+			i:    i,
+			base: base,
+			G:    g,
+			W:    g + l.MaxDistance(finish)*9/8,
+			P:    p,
+		}
+		heap.Push(openSet, w)
+	}
+	first := &hpaWalker{c: cs, i: -1}
+	for i, n := range cs.nodes {
+		if d := distStart[tileIndex(n)]; d >= 0 {
+			push(first, cs, sbase, i, d)
+		}
+	}
+	for openSet.Len() > 0 {
+		current := heap.Pop(openSet).(*hpaWalker)
+		c, i, base := current.c, current.i, current.base
+		if i < 0 {
+			return g.refine(current, start, finish, &fromStart, &fromFinish), true
+		}
+		if s.closed[base+i] || s.gScore[base+i] < current.G {
+			// stale heap entry
+			continue
+		}
+		s.closed[base+i] = true
+		for j, d := range c.dist[i] {
+			if d > 0 && !s.closed[base+j] {
+				push(current, c, base, j, current.G+d)
+			}
+		}
+		for _, out := range c.cross[i] {
+			nc, nbase := s.cluster(out.BlockId)
+			j := int(nc.index[tileIndex(out)])
+			if j >= 0 && !s.closed[nbase+j] {
+				push(current, nc, nbase, j, current.G+1)
+			}
+		}
+		if c == cf {
+			if d := distFinish[tileIndex(c.nodes[i])]; d >= 0 {
+				push(current, c, base, -1, current.G+d)
+			}
+		}
+	}
+	return nil, false
+}
+
+// Turns the abstract route ending at 'last' into a Route. fromStart and
+// fromFinish are the results of searching the clusters of start and finish
+// from start and finish.
+func (g *ClusterGraph) refine(last *hpaWalker, start, finish game.Location, fromStart, fromFinish *[blockTiles]game.Direction) (route Route) {
+	var hops []*hpaWalker
+	for n := last; n != nil; n = n.P {
+		hops = append(hops, n)
+	}
+	// hops runs from the finish back to the start
+	first := hops[len(hops)-2]
+	route = trace(fromStart, start, first.c.nodes[first.i], first.G)
+	for i := len(hops) - 2; i > 1; i-- {
+		a, b := hops[i], hops[i-1]
+		if a.c != b.c {
+			// across a border
+			route = route.join(Route{{Length: 1, D: a.c.nodes[a.i].Towards(b.c.nodes[b.i])}})
+			continue
+		}
+		route = route.join(a.c.nodePath(a.i, b.i))
+	}
+	// Final leg, inside the finish's block
+	return route.join(traceBack(fromFinish, finish, hops[1].c.nodes[hops[1].i]))
+}
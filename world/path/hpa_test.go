@@ -0,0 +1,105 @@
+package path
+
+import (
+	"jds/game"
+	"jds/game/world"
+	"math/rand"
+	"testing"
+)
+
+// Follows route from start, and returns where it ends. Fails if the route
+// crosses a wall.
+func follow(t testing.TB, w *world.World, start game.Location, route Route) game.Location {
+	l := start
+	for _, rs := range route {
+		for i := uint(0); i < rs.Length; i++ {
+			l = l.JustStep(rs.D)
+			if w.Walls.Get(l) != 0 {
+				t.Fatal("route goes through wall at", l)
+			}
+		}
+	}
+	return l
+}
+
+// Builds a room of n by n tiles, divided by walls with gaps
+func atrium(n int) (w *world.World, ul game.Location) {
+	w = world.NewWorld(0)
+	w.DrawBox(ul, ul.JustOffset(n, n))
+	for i := 1; i < 4; i++ {
+		x := i * n / 4
+		w.DrawLine(ul.JustOffset(x, 0), ul.JustOffset(x, n/2-5))
+		w.DrawLine(ul.JustOffset(x, n/2+5), ul.JustOffset(x, n-20))
+	}
+	return
+}
+
+func TestClusterRoute(t *testing.T) {
+	w, ul := atrium(300)
+	defer w.Discard()
+	rng := rand.New(rand.NewSource(1))
+	free := func() game.Location {
+		for {
+			l := ul.JustOffset(1+rng.Intn(298), 1+rng.Intn(298))
+			if w.Walls.Get(l) == 0 {
+				return l
+			}
+		}
+	}
+	g := Clusters(w)
+	for i := 0; i < 50; i++ {
+		a, b := free(), free()
+		if a.BlockId == b.BlockId {
+			continue
+		}
+		route, ok := g.Route(a, b)
+		if !ok {
+			t.Fatal("no route from", a, "to", b)
+		}
+		if end := follow(t, w, a, route); end != b {
+			t.Fatal("route from", a, "ends at", end, "not", b)
+		}
+		// HPA* routes are close to the shortest
+		if best := jps(w, a, b).Len(); route.Len() > best*5/4+game.BLOCK_SIZE/2 {
+			t.Error("route from", a, "to", b, "too long:", route.Len(), best)
+		}
+	}
+	// Only the clusters of changed blocks and their neighbors are rebuilt
+	builds := g.Builds
+	a, b := ul.JustOffset(10, 150), ul.JustOffset(290, 150)
+	w.DrawLine(ul.JustOffset(150, 100), ul.JustOffset(150, 200))
+	for i := 0; i < 2; i++ {
+		route, ok := g.Route(a, b)
+		if !ok || follow(t, w, a, route) != b {
+			t.Fatal("no route around new wall")
+		}
+	}
+	if n := g.Builds - builds; n == 0 || n > 5*5 {
+		t.Error("wrong number of clusters rebuilt:", n)
+	}
+}
+
+// Builds a room of n by n tiles, with a pillar every 50 tiles
+func pillars(n int) (w *world.World, ul game.Location) {
+	w = world.NewWorld(0)
+	w.DrawBox(ul, ul.JustOffset(n, n))
+	for x := 20; x < n-5; x += 50 {
+		for y := 20; y < n-5; y += 50 {
+			w.DrawBox(ul.JustOffset(x, y), ul.JustOffset(x+5, y+5))
+		}
+	}
+	return
+}
+
+// Routes across a 2000x2000 room must take well under a millisecond
+func BenchmarkClusterRoute(b *testing.B) {
+	b.StopTimer()
+	w, ul := pillars(2000)
+	defer w.Discard()
+	start, finish := ul.JustOffset(10, 10), ul.JustOffset(1990, 1970)
+	NewRoute(w, start, finish)
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		NewRoute(w, start, finish)
+	}
+}
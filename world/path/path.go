@@ -219,8 +219,18 @@ func (r Route) Len() (len int) {
 	return
 }
 
-// A* with Jump Points (http://grastien.net/ban/articles/hg-aaai11.pdf)
+// Returns a Route from start to finish, or nil if there is none. Routes
+// between rooms pass through Doors, see doorRoute.
 func NewRoute(w *world.World, start, finish game.Location) (route Route) {
+	return NewWeightedRoute(w, start, finish, nil)
+}
+
+// Like NewRoute, but returns the cheapest Route according to the cost layer
+// cost, such as Costs(w). cost may be nil, and is ignored if it is empty.
+func NewWeightedRoute(w *world.World, start, finish game.Location, cost *layer.Layer) (route Route) {
+	if cost != nil && cost.Empty() {
+		cost = nil
+	}
 	if start == finish {
 		return
 	}
@@ -228,10 +238,39 @@ func NewRoute(w *world.World, start, finish game.Location) (route Route) {
 		// unreachable
 		return
 	}
-	if startRid := w.RoomIds.Get(start); startRid == 0 || startRid != w.RoomIds.Get(finish) {
-		// path finding only inside 1 room (1 connected component) for now
+	startRid := world.RoomId(w.RoomIds.Get(start))
+	finishRid := world.RoomId(w.RoomIds.Get(finish))
+	if startRid == world.ROOMID_INVALID || finishRid == world.ROOMID_INVALID {
+		// path finding only inside rooms
 		return
 	}
+	if startRid != finishRid {
+		if !w.RoomGraph().Reachable(startRid, finishRid) {
+			// Don't search every door of the start room's component
+			return
+		}
+		return doorRoute(w, start, finish, startRid, finishRid, cost)
+	}
+	route, _ = roomRoute(w, start, finish, cost)
+	return
+}
+
+// Finds a Route from start to finish like NewWeightedRoute, but between
+// ticks as a job submitted to ta, see world.ActionAccumulator.Submit. done is
+// called with the Route in block bid on a later tick.
+func Request(ta *world.ActionAccumulator, w *world.World, start, finish game.Location, cost *layer.Layer, bid game.BlockId, done func(ta *world.ActionAccumulator, route Route)) {
+	var route Route
+	ta.Submit(func() {
+		route = NewWeightedRoute(w, start, finish, cost)
+	}, func(ta *world.ActionAccumulator) {
+		done(ta, route)
+	}, bid)
+}
+
+// A* with Jump Points (http://grastien.net/ban/articles/hg-aaai11.pdf)
+//
+// start and finish must be distinct Locations in the same room
+func jps(w *world.World, start, finish game.Location) (route Route) {
 	segNodePool := make([]routeSegTreeNode, 0, 100)
 	allocateSegNode := func() (n *routeSegTreeNode, idx int) {
 		segNodePool = append(segNodePool, routeSegTreeNode{})
@@ -187,7 +187,7 @@ func randomRoomLoc(r *world.Room) (loc game.Location) {
 
 func TestGridWorldWalk(t *testing.T) {
 	interiorLocs := [500]game.Location{}
-	w := generate.NewGridWorld(10, 10)
+	w := generate.NewGridWorld(10, 10, rand.Int63())
 	m := game.Min{}
 	// find largest room
 	for _, r := range w.Rooms {
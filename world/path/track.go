@@ -0,0 +1,64 @@
+// Noticing when wall changes may have blocked a Route
+//
+// A RouteTracker records the blocks a Route crosses, and the wall generation
+// it was planned at. A wall operation touching any of those blocks, see
+// World.BlockGeneration, makes the Route stale, and its follower should plan
+// a new one.
+
+package path
+
+import (
+	"jds/game"
+	"jds/game/world"
+)
+
+type RouteTracker struct {
+	blocks []game.BlockId // crossed by the Route
+	gen    uint64         // WallGeneration when last known to be fresh
+}
+
+// Returns a RouteTracker for route, followed from start
+func Track(w *world.World, start game.Location, route Route) RouteTracker {
+	rt := RouteTracker{
+		blocks: []game.BlockId{start.BlockId},
+		gen:    w.WallGeneration(),
+	}
+	l := start
+	for _, rs := range route {
+		for i := uint(0); i < rs.Length; i++ {
+			l = l.JustStep(rs.D)
+			if l.BlockId != rt.blocks[len(rt.blocks)-1] {
+				rt.blocks = append(rt.blocks, l.BlockId)
+			}
+		}
+	}
+	return rt
+}
+
+// Returns true if a wall operation has touched a block crossed by the Route
+// since it was planned, or since Stale last returned false
+func (rt *RouteTracker) Stale(w *world.World) bool {
+	gen := w.WallGeneration()
+	if gen == rt.gen {
+		return false
+	}
+	for _, bid := range rt.blocks {
+		if w.BlockGeneration(bid) > rt.gen {
+			return true
+		}
+	}
+	rt.gen = gen
+	return false
+}
+
+// Returns the part of r after its first n steps
+func (r Route) Skip(n int) (rest Route) {
+	for i, rs := range r {
+		if int(rs.Length) > n {
+			rest = append(Route{{Length: rs.Length - uint(n), D: rs.D}}, r[i+1:]...)
+			return
+		}
+		n -= int(rs.Length)
+	}
+	return
+}
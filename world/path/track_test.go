@@ -0,0 +1,33 @@
+package path
+
+import (
+	"jds/game"
+	"jds/game/world"
+	"testing"
+)
+
+func TestRouteTracker(t *testing.T) {
+	w := world.NewWorld(0)
+	defer w.Discard()
+	ul := game.Location{}
+	w.DrawBox(ul, ul.JustOffset(3*game.BLOCK_SIZE, 3*game.BLOCK_SIZE))
+	start, finish := ul.JustOffset(2, 2), ul.JustOffset(3*game.BLOCK_SIZE-2, 2)
+	route := NewRoute(w, start, finish)
+	rt := Track(w, start, route)
+	if len(rt.blocks) != 3 || rt.Stale(w) {
+		t.Fatal("wrong blocks, or fresh route stale:", rt.blocks)
+	}
+	// Walls away from the route don't make it stale
+	w.DrawLine(ul.JustOffset(10, 2*game.BLOCK_SIZE+10), ul.JustOffset(20, 2*game.BLOCK_SIZE+10))
+	if rt.Stale(w) || rt.gen != w.WallGeneration() {
+		t.Fatal("route made stale by distant wall")
+	}
+	// Walls across it do
+	w.DrawLine(ul.JustOffset(game.BLOCK_SIZE+5, 1), ul.JustOffset(game.BLOCK_SIZE+5, 10))
+	if !rt.Stale(w) || !rt.Stale(w) {
+		t.Error("route not made stale by wall across it")
+	}
+	if rest := route.Skip(5); rest.Len() != route.Len()-5 || route.Skip(route.Len()) != nil {
+		t.Error("wrong rest of route:", rest)
+	}
+}
@@ -0,0 +1,175 @@
+// Spatial queries over the EntityIds layer
+//
+// Entities are found by reading EntityIds rather than walking w.Entities, so
+// the cost of a query depends on the area searched and not on the number of
+// Entities. Blocks of EntityIds that were never written are skipped.
+//
+// The number of Entities in each room is kept up to date as Entities spawn,
+// step through doors and die. Wall operations renumber rooms, so the counts
+// are recomputed from w.Entities when rooms or doors have changed.
+
+package world
+
+import (
+	"jds/game"
+)
+
+// Returns the EntityIds of the Entities in the box with corners ul and lr,
+// inclusive, in row major order
+func (w *World) EntitiesInBox(ul, lr game.Location) (eids []EntityId) {
+	w.scanBox(ul, lr, func(eid EntityId, l game.Location) bool {
+		eids = append(eids, eid)
+		return true
+	})
+	return
+}
+
+// Returns the EntityIds of the Entities whose distance from l is at most
+// radius, in row major order
+func (w *World) EntitiesInRadius(l game.Location, radius int) (eids []EntityId) {
+	r2 := radius * radius
+	w.scanBox(l.JustOffset(-radius, -radius), l.JustOffset(radius, radius), func(eid EntityId, el game.Location) bool {
+		if l.LinearDistance(el) <= r2 {
+			eids = append(eids, eid)
+		}
+		return true
+	})
+	return
+}
+
+// Returns the EntityIds of the Entities in room rid, in row major order.
+// Entities standing in a door are in neither of its rooms.
+func (w *World) EntitiesInRoom(rid RoomId) (eids []EntityId) {
+	r := w.Rooms[rid]
+	if r == nil {
+		return
+	}
+	collect := func(eid EntityId, _ game.Location) bool {
+		eids = append(eids, eid)
+		return true
+	}
+	r.Interior(func(rm *game.RowMask) bool {
+		// Scan each run of the room's tiles in the row
+		for i := 0; i < rm.Width(); {
+			m, n := rm.Mask(i)
+			if m {
+				w.scanBox(rm.Left.JustOffset(i, 0), rm.Left.JustOffset(i+n-1, 0), collect)
+			}
+			i += n
+		}
+		return true
+	})
+	return
+}
+
+// Returns the nearest Entity to l, by MaxDistance, for which match returns
+// true. Entities further than maxDist are ignored. Of several Entities at the
+// same distance, the first in row major order is returned.
+func (w *World) NearestEntity(l game.Location, maxDist int, match func(EntityId, Entity) bool) (EntityId, bool) {
+	found := EntityId(ENTITYID_INVALID)
+	test := func(eid EntityId, _ game.Location) bool {
+		if match(eid, w.Entities[eid]) {
+			found = eid
+			return false
+		}
+		return true
+	}
+	w.scanBox(l, l, test)
+	// Search the rings of tiles at increasing distance, in row major order
+	for d := 1; d <= maxDist && found == ENTITYID_INVALID; d++ {
+		w.scanBox(l.JustOffset(-d, -d), l.JustOffset(d, -d), test)
+		for y := 1 - d; y < d && found == ENTITYID_INVALID; y++ {
+			w.scanBox(l.JustOffset(-d, y), l.JustOffset(-d, y), test)
+			if found == ENTITYID_INVALID {
+				w.scanBox(l.JustOffset(d, y), l.JustOffset(d, y), test)
+			}
+		}
+		if found == ENTITYID_INVALID {
+			w.scanBox(l.JustOffset(-d, d), l.JustOffset(d, d), test)
+		}
+	}
+	return found, found != ENTITYID_INVALID
+}
+
+// Calls f with each Entity in the box with corners ul and lr, inclusive, in
+// row major order, until f returns false
+func (w *World) scanBox(ul, lr game.Location, f func(EntityId, game.Location) bool) {
+	width, height := ul.SmallDistance(lr)
+	if width < 0 || height < 0 {
+		return
+	}
+	for y := 0; y <= height; y++ {
+		for x := 0; x <= width; {
+			l := ul.JustOffset(x, y)
+			// Skip to the next block if there are no Entities in this one
+			n := game.BLOCK_SIZE - int(l.X)
+			if !w.EntityIds.InBlockstore(l.BlockId) {
+				x += n
+				continue
+			}
+			for ; n > 0 && x <= width; n, x = n-1, x+1 {
+				if eid := EntityId(w.EntityIds.Get(l)); eid != ENTITYID_INVALID {
+					if !f(eid, l) {
+						return
+					}
+				}
+				l.X++
+			}
+		}
+	}
+}
+
+// Returns the number of Entities in room rid. Entities standing in a door are
+// in neither of its rooms. Safe for concurrent use during Think.
+func (w *World) Occupancy(rid RoomId) int {
+	w.occMutex.Lock()
+	defer w.occMutex.Unlock()
+	w.refreshOccupancy()
+	return w.occupancy[rid]
+}
+
+// Returns the number of Entities in r, see World.Occupancy
+func (r *Room) Occupancy() int {
+	return r.w.Occupancy(r.id)
+}
+
+// Returns the room containing l, or ROOMID_INVALID if l isn't in a room
+func (w *World) roomAt(l game.Location) RoomId {
+	rid := RoomId(w.RoomIds.Get(l))
+	if w.Rooms[rid] == nil {
+		return ROOMID_INVALID
+	}
+	return rid
+}
+
+// Recounts the Entities in each room, if rooms or doors have changed since
+// the last count. The caller must hold occMutex.
+func (w *World) refreshOccupancy() {
+	if w.occGen == w.generation {
+		return
+	}
+	w.occGen = w.generation
+	w.occupancy = make(map[RoomId]int)
+	for _, e := range w.Entities {
+		if rid := w.roomAt(e.Location()); rid != ROOMID_INVALID {
+			w.occupancy[rid]++
+		}
+	}
+}
+
+// Adds n to the Entity count of the room containing l
+func (w *World) occupy(l game.Location, n int) {
+	rid := w.roomAt(l)
+	if rid == ROOMID_INVALID {
+		return
+	}
+	w.occMutex.Lock()
+	if w.occGen == w.generation {
+		w.occupancy[rid] += n
+		if w.occupancy[rid] == 0 {
+			delete(w.occupancy, rid)
+		}
+	}
+	// Otherwise the next refreshOccupancy counts it
+	w.occMutex.Unlock()
+}
@@ -0,0 +1,103 @@
+package world
+
+import (
+	"jds/game"
+	"testing"
+)
+
+func TestEntityQueries(t *testing.T) {
+	w := NewWorld(0)
+	l := game.Location{}
+	w.DrawBox(l, l.JustOffset(20, 20))
+	w.DrawBox(l.JustOffset(20, 0), l.JustOffset(40, 20))
+	if w.NewDoor(l.JustOffset(19, 8), VERT, nil) == nil {
+		t.Fatal("couldn't place door")
+	}
+	left, right := RoomId(w.RoomIds.Get(l.JustOffset(5, 5))), RoomId(w.RoomIds.Get(l.JustOffset(30, 5)))
+	spawn := func(x, y int) EntityId {
+		return w.Spawn(&savedEntity{l: l.JustOffset(x, y)})
+	}
+	a, b, c := spawn(6, 5), spawn(5, 6), spawn(30, 10)
+	far := spawn(-500, -500)
+	w.Think()
+	eq := func(x, y []EntityId) bool {
+		if len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if x[i] != y[i] {
+				return false
+			}
+		}
+		return true
+	}
+	if eids := w.EntitiesInBox(l, l.JustOffset(40, 20)); !eq(eids, []EntityId{a, b, c}) {
+		t.Error("wrong entities in box:", eids)
+	}
+	if eids := w.EntitiesInBox(l.JustOffset(-600, -600), l.JustOffset(5, 5)); !eq(eids, []EntityId{far}) {
+		t.Error("wrong entities in box:", eids)
+	}
+	if eids := w.EntitiesInRadius(l.JustOffset(5, 5), 1); !eq(eids, []EntityId{a, b}) {
+		t.Error("wrong entities in radius:", eids)
+	}
+	if eids := w.EntitiesInRoom(left); !eq(eids, []EntityId{a, b}) {
+		t.Error("wrong entities in room:", eids)
+	}
+	any := func(EntityId, Entity) bool { return true }
+	notC := func(eid EntityId, _ Entity) bool { return eid != c }
+	if eid, ok := w.NearestEntity(l.JustOffset(30, 5), 50, any); !ok || eid != c {
+		t.Error("wrong nearest entity:", eid)
+	}
+	if eid, ok := w.NearestEntity(l.JustOffset(30, 5), 50, notC); !ok || eid != a {
+		t.Error("wrong nearest entity:", eid)
+	}
+	if _, ok := w.NearestEntity(l.JustOffset(30, 5), 20, notC); ok {
+		t.Error("found entity beyond maxDist")
+	}
+	// Occupancy follows Entities through doors
+	if w.Occupancy(left) != 2 || w.Occupancy(right) != 1 {
+		t.Fatal("wrong occupancy:", w.Occupancy(left), w.Occupancy(right))
+	}
+	e := &savedEntity{l: l.JustOffset(23, 9)}
+	d := w.Spawn(e)
+	sc := w.entityCursor(e.l)
+	for e.l.X > 16 {
+		var ok bool
+		if e.l, ok = w.StepEntity(d, e, &sc, game.LEFT); !ok {
+			t.Fatal("couldn't walk through door")
+		}
+		if e.l.X == 20 && w.Occupancy(left)+w.Occupancy(right) != 3 {
+			t.Error("entity in door is in a room")
+		}
+	}
+	if w.Occupancy(left) != 3 || w.Occupancy(right) != 1 {
+		t.Error("wrong occupancy after walking:", w.Occupancy(left), w.Occupancy(right))
+	}
+	w.Kill(a)
+	if w.Occupancy(left) != 2 {
+		t.Error("wrong occupancy after kill:", w.Occupancy(left))
+	}
+	// And wall operations
+	w.DrawLine(l.JustOffset(10, 0), l.JustOffset(10, 20))
+	if w.Occupancy(RoomId(w.RoomIds.Get(l.JustOffset(5, 5)))) != 1 || w.Occupancy(RoomId(w.RoomIds.Get(l.JustOffset(15, 5)))) != 1 {
+		t.Error("wrong occupancy after split")
+	}
+}
+
+func TestEntitiesInEmptyRoom(t *testing.T) {
+	w := NewWorld(0)
+	l := game.Location{}
+	// No walls in the middle block, so EntityIds has no block there
+	w.DrawBox(l, l.JustOffset(3*game.BLOCK_SIZE-1, 3*game.BLOCK_SIZE-1))
+	middle := game.BlockId{X: 1, Y: 1}
+	if w.EntityIds.InBlockstore(middle) {
+		t.Fatal("middle block allocated by wall operation")
+	}
+	if eids := w.EntitiesInRoom(RoomId(w.RoomIds.Get(l.JustOffset(5, 5)))); len(eids) != 0 {
+		t.Error("found entities in empty room:", eids)
+	}
+	// Queries only read EntityIds
+	if w.EntityIds.InBlockstore(middle) {
+		t.Error("query added block to EntityIds")
+	}
+}
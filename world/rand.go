@@ -0,0 +1,56 @@
+package world
+
+// A small, fast pseudo-random number generator (SplitMix64). Unlike
+// math/rand, a Rand is cheap to create, so one can be made for each Action.
+type Rand struct {
+	state uint64
+}
+
+func NewRand(seed uint64) Rand {
+	return Rand{state: seed}
+}
+
+func (r *Rand) Uint64() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	z := r.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// Returns a number in [0,n). Panics if n <= 0
+func (r *Rand) Intn(n int) int {
+	if n <= 0 {
+		panic("invalid argument to Intn")
+	}
+	return int(r.Uint64() % uint64(n))
+}
+
+// Returns a number in [0.0,1.0)
+func (r *Rand) Float64() float64 {
+	return float64(r.Uint64()>>11) / (1 << 53)
+}
+
+// Seeds the random number generators of w and makes Think deterministic.
+// Two Worlds with the same seed, given the same inputs, are the same after
+// every tick, however Think's workers are scheduled. Must not be called
+// during Think.
+func (w *World) SetSeed(seed int64) {
+	w.seed = seed
+	w.deterministic = true
+}
+
+// Returns the seed of w. Worlds that haven't been seeded with SetSeed get a
+// random seed from math/rand.
+func (w *World) Seed() int64 {
+	return w.seed
+}
+
+// Returns a Rand for Entity id to use during the current tick. Its numbers
+// depend only on the seed of w, id and the tick, so an Entity that only uses
+// EntityRand for randomness behaves the same in every run with the same seed,
+// and needs to save no random state in snapshots.
+func (w *World) EntityRand(id EntityId) Rand {
+	r := NewRand(uint64(w.seed) ^ uint64(id))
+	return NewRand(r.Uint64() ^ uint64(w.ticks))
+}
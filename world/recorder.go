@@ -0,0 +1,222 @@
+// Recording of Entity movement
+//
+// A Recorder set with World.SetRecorder is told of each spawn, death, step and
+// collision of every Entity. It keeps the most recent events in a ring buffer,
+// which can be exported as CSV or JSON lines, e.g. to find congestion at doors
+// or to replay the trajectory of a single Entity.
+
+package world
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"jds/game"
+	"strconv"
+	"sync"
+)
+
+type MoveKind int8
+
+const (
+	MOVE_SPAWN MoveKind = iota
+	MOVE_DEATH
+	// The Entity stepped from L in direction Dir
+	MOVE_STEP
+	// The Entity at L couldn't step in direction Dir because of a wall
+	MOVE_HIT_WALL
+	// The Entity at L couldn't step in direction Dir because of Entity Other
+	MOVE_TOUCH
+	NUM_MOVE_KINDS
+)
+
+func (k MoveKind) String() string {
+	switch k {
+	case MOVE_SPAWN:
+		return "spawn"
+	case MOVE_DEATH:
+		return "death"
+	case MOVE_STEP:
+		return "step"
+	case MOVE_HIT_WALL:
+		return "hitwall"
+	case MOVE_TOUCH:
+		return "touch"
+	}
+	return fmt.Sprintf("MoveKind(%d)", int(k))
+}
+
+type MoveEvent struct {
+	Tick   game.Tick
+	Entity EntityId
+	// Other Entity, for MOVE_TOUCH
+	Other EntityId
+	// Location of Entity before the event
+	L    game.Location
+	Kind MoveKind
+	// Direction of the step, for MOVE_STEP, MOVE_HIT_WALL and MOVE_TOUCH
+	Dir game.Direction
+}
+
+// Keeps the last MoveEvents of a World in a ring buffer. Safe for concurrent
+// use. Events recorded during Think are in the order Entities moved, which
+// varies between runs unless the World is deterministic.
+type Recorder struct {
+	m      sync.Mutex
+	events []MoveEvent
+	// Index of the next event to overwrite, once events is full
+	next int
+	// Number of events overwritten
+	dropped int
+}
+
+// Returns a Recorder keeping the last 'capacity' MoveEvents
+func NewRecorder(capacity int) *Recorder {
+	if capacity <= 0 {
+		panic("Recorder capacity must be positive")
+	}
+	return &Recorder{events: make([]MoveEvent, 0, capacity)}
+}
+
+// Sets the Recorder told of the movement of w's Entities, or stops recording
+// if r is nil. Must not be called during Think.
+func (w *World) SetRecorder(r *Recorder) {
+	w.recorder = r
+}
+
+// Records e, overwriting the oldest event if the buffer is full
+func (r *Recorder) Record(e MoveEvent) {
+	r.m.Lock()
+	if len(r.events) < cap(r.events) {
+		r.events = append(r.events, e)
+	} else {
+		r.events[r.next] = e
+		r.next = (r.next + 1) % len(r.events)
+		r.dropped++
+	}
+	r.m.Unlock()
+}
+
+// Records an event of w's Entity eid at l, if w has a Recorder
+func (w *World) record(kind MoveKind, eid EntityId, l game.Location, d game.Direction, other EntityId) {
+	if w.recorder != nil {
+		w.recorder.Record(MoveEvent{
+			Tick:   w.ticks,
+			Entity: eid,
+			Other:  other,
+			L:      l,
+			Kind:   kind,
+			Dir:    d,
+		})
+	}
+}
+
+// Returns the recorded events, oldest first
+func (r *Recorder) Events() []MoveEvent {
+	r.m.Lock()
+	defer r.m.Unlock()
+	events := make([]MoveEvent, 0, len(r.events))
+	events = append(events, r.events[r.next:]...)
+	return append(events, r.events[:r.next]...)
+}
+
+// Returns the number of events that were overwritten by newer ones
+func (r *Recorder) Dropped() int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.dropped
+}
+
+// Discards the recorded events
+func (r *Recorder) Reset() {
+	r.m.Lock()
+	r.events = r.events[:0]
+	r.next = 0
+	r.dropped = 0
+	r.m.Unlock()
+}
+
+// Returns the recorded locations of Entity eid, oldest first. Each step adds
+// the Location stepped to.
+func (r *Recorder) Trajectory(eid EntityId) (trajectory []game.Location) {
+	for _, e := range r.Events() {
+		if e.Entity != eid {
+			continue
+		}
+		switch e.Kind {
+		case MOVE_SPAWN:
+			trajectory = append(trajectory, e.L)
+		case MOVE_STEP:
+			if len(trajectory) == 0 {
+				// Spawned before the oldest event
+				trajectory = append(trajectory, e.L)
+			}
+			trajectory = append(trajectory, e.L.JustStep(e.Dir))
+		}
+	}
+	return
+}
+
+// Field names of the CSV and JSON lines exports
+var moveEventFields = []string{"tick", "kind", "entity", "x", "y", "dir", "other"}
+
+// Returns the fields of e, with its Location as absolute tile coordinates
+func (e MoveEvent) fields() []string {
+	x, y := game.Location{}.Distance(e.L)
+	dir := ""
+	if e.Kind >= MOVE_STEP {
+		dir = e.Dir.String()
+	}
+	return []string{
+		strconv.Itoa(int(e.Tick)),
+		e.Kind.String(),
+		strconv.Itoa(int(e.Entity)),
+		strconv.FormatInt(x, 10),
+		strconv.FormatInt(y, 10),
+		dir,
+		strconv.Itoa(int(e.Other)),
+	}
+}
+
+// Writes the recorded events to wr as CSV, oldest first, with a header row
+func (r *Recorder) WriteCSV(wr io.Writer) error {
+	cw := csv.NewWriter(wr)
+	if err := cw.Write(moveEventFields); err != nil {
+		return err
+	}
+	for _, e := range r.Events() {
+		if err := cw.Write(e.fields()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Writes the recorded events to wr as JSON lines, oldest first. Each line is
+// an object with the fields of the CSV export.
+func (r *Recorder) WriteJSONLines(wr io.Writer) error {
+	bw := bufio.NewWriter(wr)
+	enc := json.NewEncoder(bw)
+	for _, e := range r.Events() {
+		x, y := game.Location{}.Distance(e.L)
+		rec := struct {
+			Tick   game.Tick `json:"tick"`
+			Kind   string    `json:"kind"`
+			Entity EntityId  `json:"entity"`
+			X      int64     `json:"x"`
+			Y      int64     `json:"y"`
+			Dir    string    `json:"dir,omitempty"`
+			Other  EntityId  `json:"other,omitempty"`
+		}{e.Tick, e.Kind.String(), e.Entity, x, y, "", e.Other}
+		if e.Kind >= MOVE_STEP {
+			rec.Dir = e.Dir.String()
+		}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,64 @@
+package world
+
+import (
+	"bytes"
+	"fmt"
+	"jds/game"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	w := NewWorld(0)
+	l := game.Location{}
+	w.DrawBox(l, l.JustOffset(10, 10))
+	r := NewRecorder(100)
+	w.SetRecorder(r)
+	e := &savedEntity{l: l.JustOffset(3, 5)}
+	eid := w.Spawn(e)
+	other := w.Spawn(&savedEntity{l: l.JustOffset(1, 4)})
+	sc := w.entityCursor(e.l)
+	for _, d := range []game.Direction{game.LEFT, game.UP, game.LEFT, game.LEFTDOWN, game.LEFT} {
+		e.l, _ = w.StepEntity(eid, e, &sc, d)
+	}
+	w.Kill(eid)
+	kinds := ""
+	for _, ev := range r.Events() {
+		kinds += ev.Kind.String() + " "
+	}
+	if kinds != "spawn spawn step step touch step hitwall death " {
+		t.Fatal("wrong events:", kinds)
+	}
+	if touch := r.Events()[4]; touch.Other != other || touch.Dir != game.LEFT {
+		t.Error("wrong touch event:", touch)
+	}
+	tr := r.Trajectory(eid)
+	if len(tr) != 4 || tr[0] != l.JustOffset(3, 5) || tr[3] != l.JustOffset(1, 5) {
+		t.Error("wrong trajectory:", tr)
+	}
+	var buf bytes.Buffer
+	if err := r.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 9 || lines[5] != fmt.Sprintf("0,touch,%d,2,4,Left,%d", eid, other) {
+		t.Error("wrong CSV:", lines)
+	}
+	buf.Reset()
+	if err := r.WriteJSONLines(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 8 || lines[0] != fmt.Sprintf(`{"tick":0,"kind":"spawn","entity":%d,"x":3,"y":5}`, eid) {
+		t.Error("wrong JSON lines:", lines)
+	}
+	// The oldest events are overwritten
+	r = NewRecorder(3)
+	w.SetRecorder(r)
+	for i := 0; i < 5; i++ {
+		w.Kill(w.Spawn(&savedEntity{l: l.JustOffset(5, 5)}))
+	}
+	if events := r.Events(); len(events) != 3 || r.Dropped() != 7 || events[0].Kind != MOVE_DEATH || events[2].Kind != MOVE_DEATH {
+		t.Error("wrong events after overwriting:", events, r.Dropped())
+	}
+}
@@ -54,6 +54,7 @@ func (r *Room) LinkingNode() (n WallTreeNode) {
 // Set RoomId for all interior tiles
 func (r *Room) init(m game.ModMap) {
 	var room *Room
+	r.w.touchRoom(r.id)
 	r.Area = 0
 	replacing := make(map[RoomId]int)
 	replacingRid := RoomId(ROOMID_INVALID)
@@ -98,6 +99,7 @@ func (r *Room) init(m game.ModMap) {
 								if room.id != replacingRid {
 									panic("replacing rid wrong")
 								}
+								r.w.touchRoom(replacingRid)
 								room.Area -= runLength
 							}
 						}
@@ -118,6 +120,7 @@ func (r *Room) init(m game.ModMap) {
 						replacing[replacingRid] += runLength
 						room = r.w.Rooms[replacingRid]
 						if room != nil {
+							r.w.touchRoom(replacingRid)
 							room.Area -= runLength
 						}
 					}
@@ -147,6 +150,11 @@ func (r *Room) init(m game.ModMap) {
 			doorRoom.addDoorId(d.Id)
 		}
 	}
+	for rid := range replacing {
+		r.w.touchRoom(rid)
+		r.w.roomLog.moved(rid, r.id)
+	}
+	r.w.inheritZoning(r, replacing)
 	if len(replacing) == 1 {
 		// Only 1 RoomId was replaced
 		var original RoomId
@@ -346,13 +354,19 @@ func (r *Room) paint(f func(*game.RowMask, []game.TileId) bool) {
 }
 
 // Debug -- check if a room is connected (very slow)
-func (r *Room) checkConnected() {
+func (r *Room) checkConnected() []FsckError {
 	f := r.w.RoomIds.DeepSearch(game.TileId(r.id))
 	if len(f) != r.Area {
-		panic("Area is wrong")
+		return []FsckError{{
+			Category: FSCK_ROOM_AREA,
+			Message:  fmt.Sprint("area ", len(f), " recorded area ", r.Area),
+			L:        r.LNL,
+			RoomId:   r.id,
+			Layer:    layer.NewLayerFromSlice(f, game.TileId(r.id)),
+		}}
 	}
 	if len(f) == 0 {
-		return
+		return nil
 	}
 	c := len(f)
 	for range r.w.RoomIds.Flood(f[0]) {
@@ -360,12 +374,15 @@ func (r *Room) checkConnected() {
 	}
 	if c != 0 {
 		// A room has become disconnected. this is an error.
-		disconnectedRoom := layer.NewLayerFromSlice(f, game.TileId(r.id))
-		panic(LayerError{
-			Layer:   disconnectedRoom,
-			Message: "room not connected",
-		})
+		return []FsckError{{
+			Category: FSCK_ROOM_CONNECTED,
+			Message:  "room not connected",
+			L:        f[0],
+			RoomId:   r.id,
+			Layer:    layer.NewLayerFromSlice(f, game.TileId(r.id)),
+		}}
 	}
+	return nil
 }
 
 func (r *Room) addDoorId(d DoorId) {
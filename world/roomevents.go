@@ -0,0 +1,232 @@
+// Room lifecycle events
+//
+// Rooms are created, deleted, repainted and renumbered in several steps
+// during a wall operation, and are only consistent once it completes. So
+// rather than reporting each step, the World keeps a log of the rooms an
+// operation touches, the RoomIds whose tiles were taken by other rooms, and
+// the RoomId remaps done. When the operation completes the log is reduced
+// to a list of RoomEvents describing the net change, which are passed to
+// each function registered with SubscribeRooms.
+
+package world
+
+import (
+	"sort"
+)
+
+// A change to the rooms of a World, one of the Room* types below
+type RoomEvent interface {
+	roomEvent()
+}
+
+// A room that didn't exist before the operation
+type RoomCreated struct {
+	Id RoomId
+}
+
+// A room that no longer exists, and wasn't merged into another room
+type RoomDeleted struct {
+	Id RoomId
+}
+
+// Room Parent was divided into Children. Parent may be among the Children,
+// if some part of it kept its RoomId.
+type RoomSplit struct {
+	Parent   RoomId
+	Children []RoomId
+}
+
+// Rooms Parents were joined into Child. Child may be among the Parents.
+type RoomMerged struct {
+	Parents []RoomId
+	Child   RoomId
+}
+
+// The room that was Old is now New
+type RoomIdRemapped struct {
+	Old, New RoomId
+}
+
+// Room Id kept its RoomId, but its area changed from Old to New
+type AreaChanged struct {
+	Id       RoomId
+	Old, New int
+}
+
+func (RoomCreated) roomEvent()    {}
+func (RoomDeleted) roomEvent()    {}
+func (RoomSplit) roomEvent()      {}
+func (RoomMerged) roomEvent()     {}
+func (RoomIdRemapped) roomEvent() {}
+func (AreaChanged) roomEvent()    {}
+
+type roomSubscriber struct {
+	id int
+	f  func(RoomEvent)
+}
+
+// State of a room when an operation first touched it
+type roomBefore struct {
+	existed bool
+	area    int
+}
+
+// Log of the room changes made by the current operation
+type roomLog struct {
+	before map[RoomId]roomBefore
+	// Tiles of room [0] were taken by room [1]
+	edges   [][2]RoomId
+	remaps  []roomIdRemap
+	nextSub int
+	subs    []roomSubscriber
+}
+
+// Registers f to be called with each RoomEvent, after each wall operation
+// completes. f is called in the order events are found, with the World in
+// a consistent state, but must not modify walls or doors itself. Returns a
+// function that unregisters f. Must not be called during Think.
+func (w *World) SubscribeRooms(f func(RoomEvent)) (unsubscribe func()) {
+	id := w.roomLog.nextSub
+	w.roomLog.nextSub++
+	w.roomLog.subs = append(w.roomLog.subs, roomSubscriber{id, f})
+	return func() {
+		for i, s := range w.roomLog.subs {
+			if s.id == id {
+				w.roomLog.subs = append(w.roomLog.subs[:i], w.roomLog.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Records the state of room rid before the current operation modifies it.
+// Called by touchRoom.
+func (rl *roomLog) touch(w *World, rid RoomId) {
+	if _, ok := rl.before[rid]; ok {
+		return
+	}
+	if rl.before == nil {
+		rl.before = make(map[RoomId]roomBefore)
+	}
+	r := w.Rooms[rid]
+	if r == nil {
+		rl.before[rid] = roomBefore{}
+	} else {
+		rl.before[rid] = roomBefore{true, r.Area}
+	}
+}
+
+// Records that room 'to' took tiles from room 'from'
+func (rl *roomLog) moved(from, to RoomId) {
+	if from != ROOMID_INVALID && to != ROOMID_INVALID && from != to {
+		rl.edges = append(rl.edges, [2]RoomId{from, to})
+	}
+}
+
+func (rl *roomLog) remapped(old, new RoomId) {
+	rl.remaps = append(rl.remaps, roomIdRemap{Old: old, New: new})
+}
+
+// Returns the RoomId that rid had at the end of the operation
+func (rl *roomLog) resolve(rid RoomId) RoomId {
+	for _, r := range rl.remaps {
+		if r.Old == rid {
+			rid = r.New
+		}
+	}
+	return rid
+}
+
+func sortRoomIds(rids []RoomId) {
+	sort.Slice(rids, func(i, j int) bool { return rids[i] < rids[j] })
+}
+
+// Reduces the log to RoomEvents, and clears it
+func (rl *roomLog) events(w *World) (events []RoomEvent) {
+	// Rooms that existed before, and after, the operation
+	var pre, post []RoomId
+	for rid, b := range rl.before {
+		if b.existed {
+			pre = append(pre, rid)
+		}
+	}
+	seen := make(map[RoomId]bool)
+	for rid := range rl.before {
+		rid = rl.resolve(rid)
+		if w.Rooms[rid] != nil && !seen[rid] {
+			seen[rid] = true
+			post = append(post, rid)
+		}
+	}
+	sortRoomIds(pre)
+	sortRoomIds(post)
+	// Find the rooms each room took tiles from, directly or via rooms that
+	// only existed during the operation
+	from := make(map[RoomId][]RoomId)
+	for _, e := range rl.edges {
+		a, b := rl.resolve(e[0]), rl.resolve(e[1])
+		if a != b {
+			from[b] = append(from[b], a)
+		}
+	}
+	parents := make(map[RoomId][]RoomId)
+	children := make(map[RoomId][]RoomId)
+	for _, rid := range post {
+		visited := map[RoomId]bool{rid: true}
+		q := []RoomId{rid}
+		for len(q) > 0 {
+			var r RoomId
+			r, q = q[0], q[1:]
+			if rl.before[r].existed {
+				parents[rid] = append(parents[rid], r)
+				children[r] = append(children[r], rid)
+			}
+			for _, f := range from[r] {
+				if !visited[f] {
+					visited[f] = true
+					q = append(q, f)
+				}
+			}
+		}
+		sortRoomIds(parents[rid])
+	}
+	for _, rid := range pre {
+		if len(children[rid]) == 0 {
+			events = append(events, RoomDeleted{rid})
+		}
+	}
+	for _, rid := range post {
+		switch p := parents[rid]; {
+		case len(p) == 0:
+			events = append(events, RoomCreated{rid})
+		case len(p) > 1:
+			events = append(events, RoomMerged{p, rid})
+		case p[0] != rid && len(children[p[0]]) == 1:
+			events = append(events, RoomIdRemapped{p[0], rid})
+		}
+	}
+	for _, rid := range pre {
+		if c := children[rid]; len(c) > 1 {
+			events = append(events, RoomSplit{rid, c})
+		}
+	}
+	for _, rid := range post {
+		if b := rl.before[rid]; b.existed && b.area != w.Rooms[rid].Area {
+			events = append(events, AreaChanged{rid, b.area, w.Rooms[rid].Area})
+		}
+	}
+	rl.before = nil
+	rl.edges = nil
+	rl.remaps = nil
+	return
+}
+
+// Passes the RoomEvents of the completed operation to subscribers
+func (w *World) emitRoomEvents() {
+	events := w.roomLog.events(w)
+	for _, e := range events {
+		for _, s := range w.roomLog.subs {
+			s.f(e)
+		}
+	}
+}
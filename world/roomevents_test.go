@@ -0,0 +1,73 @@
+package world
+
+import (
+	"jds/game"
+	"reflect"
+	"testing"
+)
+
+func TestRoomEvents(t *testing.T) {
+	w := NewWorld(STRICT_ALL)
+	l := game.Location{}
+	var events []RoomEvent
+	unsubscribe := w.SubscribeRooms(func(e RoomEvent) {
+		events = append(events, e)
+	})
+	// Returns the events since the last call, except AreaChanged
+	changes := func() (c []RoomEvent) {
+		for _, e := range events {
+			if _, ok := e.(AreaChanged); !ok {
+				c = append(c, e)
+			}
+		}
+		events = nil
+		return
+	}
+	expect := func(what string, want ...RoomEvent) {
+		if got := changes(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %v, want %v", what, got, want)
+		}
+	}
+	rid := func(l game.Location) RoomId {
+		return RoomId(w.RoomIds.Get(l))
+	}
+	w.DrawBox(l, l.JustOffset(40, 20))
+	a := rid(l.JustOffset(5, 5))
+	expect("box", RoomCreated{a})
+	w.SetWall(l.JustOffset(25, 1))
+	if len(events) != 1 || events[0] != (AreaChanged{a, 741, 740}) {
+		t.Fatal("wrong area change:", events)
+	}
+	w.DrawLine(l.JustOffset(25, 0), l.JustOffset(25, 20))
+	b := rid(l.JustOffset(35, 5))
+	expect("split", RoomSplit{a, []RoomId{a, b}})
+	w.DeleteFromWallTree(l.JustOffset(25, 10))
+	expect("merge", RoomMerged{[]RoomId{a, b}, a})
+	// Joining two wall complexes rebuilds the rooms of the smaller one
+	w.DrawBox(l.JustOffset(50, 0), l.JustOffset(70, 20))
+	c := rid(l.JustOffset(60, 10))
+	changes()
+	// Checks that the only change is a new RoomId for room c
+	moved := func(what string, got []RoomEvent) {
+		for _, e := range got {
+			r, ok := e.(RoomIdRemapped)
+			if !ok || r.Old != c || r.New != rid(l.JustOffset(60, 10)) || w.Rooms[c] != nil {
+				t.Fatalf("%s: wrong event %v", what, e)
+			}
+			c = r.New
+		}
+	}
+	w.DrawLine(l.JustOffset(40, 10), l.JustOffset(50, 10))
+	moved("join", changes())
+	w.DeleteFromWallTree(l.JustOffset(10, 0))
+	got := changes()
+	if len(got) == 0 || got[0] != (RoomDeleted{a}) {
+		t.Fatal("delete: got", got)
+	}
+	moved("delete", got[1:])
+	unsubscribe()
+	w.DrawBox(l.JustOffset(0, 30), l.JustOffset(10, 40))
+	if len(events) != 0 {
+		t.Error("events after unsubscribe")
+	}
+}
@@ -0,0 +1,199 @@
+// The graph of rooms joined by doors
+
+package world
+
+import (
+	"sort"
+)
+
+// An edge of the RoomGraph, leaving a room through Door
+type RoomEdge struct {
+	// The room on the other side of Door
+	Room RoomId
+	Door DoorId
+	// Index into Door.DoorSteps() of the side the edge leaves from
+	Side int
+	// True if routes may cross Door this way, see Door.Routable
+	Routable bool
+}
+
+// The rooms of a World as nodes, joined by the Doors between them. Doors
+// facing the outside, where Door.R is ROOMID_INVALID, aren't part of the
+// graph.
+//
+// A RoomGraph is immutable, and describes the World when it was returned by
+// World.RoomGraph.
+type RoomGraph struct {
+	gen   uint64
+	edges map[RoomId][]RoomEdge
+	// Connected component of each room, see Component
+	component map[RoomId]int
+	// Rooms of each component, in increasing order
+	components [][]RoomId
+	// Rooms that can be reached from outside, see ReachableFromOutside
+	outside map[RoomId]bool
+}
+
+// Returns the RoomGraph of w. The graph is rebuilt when rooms or doors have
+// changed since the last call. Safe for concurrent use during Think.
+func (w *World) RoomGraph() *RoomGraph {
+	w.clMutex.Lock()
+	defer w.clMutex.Unlock()
+	if w.roomGraph == nil || w.roomGraph.gen != w.generation {
+		w.roomGraph = newRoomGraph(w)
+	}
+	return w.roomGraph
+}
+
+func newRoomGraph(w *World) *RoomGraph {
+	g := &RoomGraph{
+		gen:       w.generation,
+		edges:     make(map[RoomId][]RoomEdge),
+		component: make(map[RoomId]int),
+		outside:   make(map[RoomId]bool),
+	}
+	rids := make([]RoomId, 0, len(w.Rooms))
+	for rid := range w.Rooms {
+		rids = append(rids, rid)
+	}
+	sortRoomIds(rids)
+	for _, rid := range rids {
+		var edges []RoomEdge
+		for _, did := range w.Rooms[rid].DoorIds {
+			d := w.Doors[did]
+			for i := range d.R {
+				other := d.R[1-i]
+				if d.R[i] == rid && other == ROOMID_INVALID && d.Routable(1-i) {
+					// An entrance
+					g.outside[rid] = true
+				}
+				if d.R[i] == rid && other != ROOMID_INVALID && other != rid {
+					edges = append(edges, RoomEdge{other, did, i, d.Routable(i)})
+				}
+			}
+		}
+		sort.Slice(edges, func(i, j int) bool {
+			if edges[i].Room != edges[j].Room {
+				return edges[i].Room < edges[j].Room
+			}
+			return edges[i].Door < edges[j].Door
+		})
+		g.edges[rid] = edges
+	}
+	// Label connected components breadth first, in RoomId order
+	for _, rid := range rids {
+		if _, ok := g.component[rid]; ok {
+			continue
+		}
+		c := len(g.components)
+		g.component[rid] = c
+		members := []RoomId{rid}
+		for q := []RoomId{rid}; len(q) > 0; q = q[1:] {
+			for _, e := range g.edges[q[0]] {
+				if _, ok := g.component[e.Room]; !ok {
+					g.component[e.Room] = c
+					members = append(members, e.Room)
+					q = append(q, e.Room)
+				}
+			}
+		}
+		sortRoomIds(members)
+		g.components = append(g.components, members)
+	}
+	// Find the rooms reachable from the entrances
+	var q []RoomId
+	for _, rid := range rids {
+		if g.outside[rid] {
+			q = append(q, rid)
+		}
+	}
+	for ; len(q) > 0; q = q[1:] {
+		for _, e := range g.edges[q[0]] {
+			if !g.outside[e.Room] && e.Routable {
+				g.outside[e.Room] = true
+				q = append(q, e.Room)
+			}
+		}
+	}
+	return g
+}
+
+// Returns the edges leaving room rid, ordered by the RoomId they lead to,
+// then DoorId. Door states are ignored. The returned slice must not be
+// modified.
+func (g *RoomGraph) Neighbors(rid RoomId) []RoomEdge {
+	return g.edges[rid]
+}
+
+// Returns the index of the connected component containing rid, or -1 if
+// there is no such room. Rooms in the same component are joined by doors,
+// ignoring door states.
+func (g *RoomGraph) Component(rid RoomId) int {
+	if c, ok := g.component[rid]; ok {
+		return c
+	}
+	return -1
+}
+
+// Returns the rooms of each connected component, in increasing order. The
+// returned slices must not be modified.
+func (g *RoomGraph) Components() [][]RoomId {
+	return g.components
+}
+
+// Returns the edges to follow from room 'from' to room 'to' crossing the
+// fewest doors, using only Routable edges.
+// Returns an empty, non-nil path if from == to, and nil if 'to' can't be
+// reached.
+func (g *RoomGraph) Path(from, to RoomId) []RoomEdge {
+	if _, ok := g.edges[from]; !ok {
+		return nil
+	}
+	if _, ok := g.edges[to]; !ok {
+		return nil
+	}
+	if from == to {
+		return []RoomEdge{}
+	}
+	if g.Component(from) != g.Component(to) {
+		return nil
+	}
+	type step struct {
+		prev RoomId
+		e    RoomEdge
+	}
+	visited := map[RoomId]step{from: {}}
+	for q := []RoomId{from}; len(q) > 0; q = q[1:] {
+		for _, e := range g.edges[q[0]] {
+			if _, ok := visited[e.Room]; ok || !e.Routable {
+				continue
+			}
+			visited[e.Room] = step{q[0], e}
+			if e.Room != to {
+				q = append(q, e.Room)
+				continue
+			}
+			// Collect the path from 'to' back to 'from'
+			var path []RoomEdge
+			for rid := to; rid != from; rid = visited[rid].prev {
+				path = append(path, visited[rid].e)
+			}
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return path
+		}
+	}
+	return nil
+}
+
+// Returns true if room 'to' can be reached from room 'from', see Path
+func (g *RoomGraph) Reachable(from, to RoomId) bool {
+	return g.Path(from, to) != nil
+}
+
+// Returns true if room rid can be reached from outside the rooms, through a
+// door facing the outside and then doors whose states allow it
+func (g *RoomGraph) ReachableFromOutside(rid RoomId) bool {
+	return g.outside[rid]
+}
@@ -0,0 +1,64 @@
+package world
+
+import (
+	"jds/game"
+	"reflect"
+	"testing"
+)
+
+func TestRoomGraph(t *testing.T) {
+	w := NewWorld(0)
+	l := game.Location{}
+	// A row of 3 rooms joined by doors, with an entrance on the left, and a
+	// room on its own
+	var rooms [4]RoomId
+	for i := 0; i < 3; i++ {
+		w.DrawBox(l.JustOffset(10*i, 0), l.JustOffset(10*i+10, 10))
+	}
+	w.DrawBox(l.JustOffset(40, 0), l.JustOffset(50, 10))
+	for i := range rooms {
+		rooms[i] = RoomId(w.RoomIds.Get(l.JustOffset(10*i+5, 5)))
+	}
+	entrance := w.NewDoor(l.JustOffset(-1, 3), VERT, nil)
+	d1 := w.NewDoor(l.JustOffset(9, 3), VERT, nil)
+	d2 := w.NewDoor(l.JustOffset(19, 3), VERT, nil)
+	if entrance == nil || d1 == nil || d2 == nil {
+		t.Fatal("couldn't place doors")
+	}
+	g := w.RoomGraph()
+	if w.RoomGraph() != g {
+		t.Error("unchanged graph rebuilt")
+	}
+	want := []RoomEdge{{rooms[0], d1.Id, 1, true}, {rooms[2], d2.Id, 0, true}}
+	if n := g.Neighbors(rooms[1]); !reflect.DeepEqual(n, want) {
+		t.Error("wrong neighbors:", n)
+	}
+	if g.Component(rooms[0]) != g.Component(rooms[2]) || g.Component(rooms[0]) == g.Component(rooms[3]) || len(g.Components()) != 2 {
+		t.Error("wrong components:", g.Components())
+	}
+	if p := g.Path(rooms[0], rooms[2]); len(p) != 2 || p[0].Door != d1.Id || p[1].Room != rooms[2] {
+		t.Error("wrong path:", p)
+	}
+	if p := g.Path(rooms[1], rooms[1]); p == nil || len(p) != 0 {
+		t.Error("wrong path to self:", p)
+	}
+	if g.Reachable(rooms[0], rooms[3]) || g.ReachableFromOutside(rooms[3]) || !g.ReachableFromOutside(rooms[2]) {
+		t.Error("wrong reachability")
+	}
+	// Door states are respected
+	w.SetDoorState(d2.Id, DOOR_ONEWAY)
+	g = w.RoomGraph()
+	if !g.Reachable(rooms[1], rooms[2]) || g.Reachable(rooms[2], rooms[1]) {
+		t.Error("one-way door ignored")
+	}
+	w.SetDoorState(entrance.Id, DOOR_LOCKED)
+	if g = w.RoomGraph(); g.ReachableFromOutside(rooms[0]) {
+		t.Error("locked entrance ignored")
+	}
+	// Wall changes are tracked
+	w.DeleteFromWallTree(l.JustOffset(30, 5))
+	g = w.RoomGraph()
+	if g.Component(rooms[2]) != -1 || len(g.Neighbors(rooms[1])) != 1 {
+		t.Error("graph not updated after room deleted")
+	}
+}
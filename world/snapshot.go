@@ -0,0 +1,441 @@
+package world
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"jds/game"
+	"jds/game/layer"
+	"sort"
+)
+
+// World snapshot format
+//
+//   snapshotHeader
+//   snapshotState
+//   Walls layer (see layer.Encode)
+//   DoorIds layer
+//   uint32 door count, followed by that many doorRecords
+//   uint32 zoned room count, followed by that many zoningRecords
+//   uint32 entity count, followed by that many entity records:
+//     entityRecord, type name bytes, data bytes
+//
+// Version 1 entity records have no Spawning field, and all their entities
+// are spawned on Load. Versions 1 and 2 have no Seed or Deterministic
+// fields in snapshotState, and get a random seed on Load. Versions 1 to 3
+// have no zoning. Versions 1 to 4 have no State in doorRecords, and all
+// their doors are open.
+//
+// All integers are little endian. WallNodes, Rooms, RoomIds and ForcedFlags
+// are not saved, they are rebuilt on Load by replaying the walls.
+
+// Current snapshot version. Increment when the format changes, and teach
+// Load to migrate or reject the old version.
+const SnapshotVersion = 5
+
+var snapshotMagic = [8]byte{'S', 'P', 'C', 'M', 'A', 'L', 'L', 0}
+
+var ErrNotSnapshot = errors.New("not a world snapshot")
+
+type snapshotHeader struct {
+	Magic   [8]byte
+	Version uint32
+}
+
+type snapshotStateV2 struct {
+	Ticks        int64
+	NextDoorId   int64
+	NextEntityId int64
+}
+
+type snapshotState struct {
+	snapshotStateV2
+	Seed          int64
+	Deterministic bool
+}
+
+type doorRecordV4 struct {
+	Id   int64
+	Horz bool
+	BX   int64 // BlockId of L
+	BY   int64
+	X, Y int8
+}
+
+type doorRecord struct {
+	doorRecordV4
+	State int8
+}
+
+// Zoning of the room containing location B,X,Y. RoomIds aren't saved, as
+// they may change when the rooms are rebuilt.
+type zoningRecord struct {
+	BX, BY   int64
+	X, Y     int8
+	Category int64
+	Capacity int64
+	Rent     int64
+	Opens    int64
+	Closes   int64
+}
+
+type entityRecordV1 struct {
+	Id      int64
+	TypeLen uint16
+	DataLen uint32
+}
+
+type entityRecord struct {
+	entityRecordV1
+	Spawning bool // Spawned event has not happened yet
+}
+
+// An Entity that can be saved in a World snapshot. Its type must be
+// registered with RegisterEntity under the name returned by EntityType.
+type EntityMarshaler interface {
+	Entity
+	EntityType() string
+	Marshal() ([]byte, error)
+}
+
+// An Entity that can be restored from a World snapshot. Unmarshal is called
+// before the Entity is spawned, and must restore its Location.
+type EntityUnmarshaler interface {
+	Entity
+	Unmarshal(data []byte) error
+}
+
+// An EntityUnmarshaler that resumes where it left off when restored from a
+// snapshot. Restored is called instead of Spawned, with the same arguments,
+// before the first tick after Load. Entities saved before their Spawned
+// event are spawned as usual. It must reschedule any Actions that were
+// pending when the snapshot was saved, at their original ticks.
+type EntityRestorer interface {
+	EntityUnmarshaler
+	Restored(ta *ActionAccumulator, id EntityId, w *World, sc *layer.StackCursor)
+}
+
+// Returns a new, empty Entity of a registered type
+type EntityFactory func() EntityUnmarshaler
+
+var entityRegistry = make(map[string]EntityFactory)
+
+// Registers an Entity type under a stable name, so it can be restored from
+// snapshots. Call from an init function. Panics if name is already in use.
+func RegisterEntity(name string, f EntityFactory) {
+	if _, ok := entityRegistry[name]; ok {
+		panic("entity type " + name + " registered twice")
+	}
+	entityRegistry[name] = f
+}
+
+// Sticky error writer
+type snapshotWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (s *snapshotWriter) write(v interface{}) {
+	if s.err == nil {
+		s.err = binary.Write(s.w, binary.LittleEndian, v)
+	}
+}
+
+func (s *snapshotWriter) encode(l *layer.Layer) {
+	if s.err == nil {
+		s.err = l.Encode(s.w)
+	}
+}
+
+// Writes a snapshot of w to out. Entities that do not implement
+// EntityMarshaler are not saved, nor are pending Actions. Must not be called
+// during Think.
+func (w *World) Save(out io.Writer) error {
+	s := &snapshotWriter{w: out}
+	s.write(snapshotHeader{snapshotMagic, SnapshotVersion})
+	s.write(snapshotState{
+		snapshotStateV2{
+			Ticks:        int64(w.ticks),
+			NextDoorId:   int64(w.nextDoorId),
+			NextEntityId: int64(w.nextEntityId),
+		},
+		w.seed,
+		w.deterministic,
+	})
+	s.encode(w.Walls)
+	s.encode(w.DoorIds)
+	// Doors, sorted by DoorId
+	dids := make([]int, 0, len(w.Doors))
+	for did := range w.Doors {
+		dids = append(dids, int(did))
+	}
+	sort.Ints(dids)
+	s.write(uint32(len(dids)))
+	for _, did := range dids {
+		d := w.Doors[DoorId(did)]
+		s.write(doorRecord{
+			doorRecordV4{
+				Id:   int64(d.Id),
+				Horz: bool(d.O),
+				BX:   int64(d.L.BlockId.X),
+				BY:   int64(d.L.BlockId.Y),
+				X:    d.L.X,
+				Y:    d.L.Y,
+			},
+			int8(d.State),
+		})
+	}
+	// Zoning, sorted by location
+	zoning := make([]zoningRecord, 0, len(w.zoning))
+	for rid, z := range w.zoning {
+		r := w.Rooms[rid]
+		if r == nil {
+			continue
+		}
+		l, ok := r.firstTile()
+		if !ok {
+			continue
+		}
+		zoning = append(zoning, zoningRecord{
+			BX:       int64(l.BlockId.X),
+			BY:       int64(l.BlockId.Y),
+			X:        l.X,
+			Y:        l.Y,
+			Category: int64(z.Category),
+			Capacity: int64(z.Capacity),
+			Rent:     int64(z.Rent),
+			Opens:    int64(z.Opens),
+			Closes:   int64(z.Closes),
+		})
+	}
+	sort.Slice(zoning, func(i, j int) bool {
+		a, b := zoning[i], zoning[j]
+		if a.BY*game.BLOCK_SIZE+int64(a.Y) != b.BY*game.BLOCK_SIZE+int64(b.Y) {
+			return a.BY*game.BLOCK_SIZE+int64(a.Y) < b.BY*game.BLOCK_SIZE+int64(b.Y)
+		}
+		return a.BX*game.BLOCK_SIZE+int64(a.X) < b.BX*game.BLOCK_SIZE+int64(b.X)
+	})
+	s.write(uint32(len(zoning)))
+	for _, zr := range zoning {
+		s.write(zr)
+	}
+	// Entities, sorted by EntityId
+	eids := make([]int, 0, len(w.Entities))
+	for eid, e := range w.Entities {
+		if _, ok := e.(EntityMarshaler); ok {
+			eids = append(eids, int(eid))
+		}
+	}
+	sort.Ints(eids)
+	s.write(uint32(len(eids)))
+	for _, eid := range eids {
+		e := w.Entities[EntityId(eid)].(EntityMarshaler)
+		name := e.EntityType()
+		if _, ok := entityRegistry[name]; !ok {
+			return fmt.Errorf("entity type %q not registered", name)
+		}
+		// Entities that aren't EntityRestorers are spawned on Load anyway.
+		// Leave the flag unset for them, so that a loaded World saves the
+		// same snapshot.
+		_, restorer := e.(EntityRestorer)
+		spawning := restorer && w.spawning[EntityId(eid)]
+		data, err := e.Marshal()
+		if err != nil {
+			return err
+		}
+		s.write(entityRecord{
+			entityRecordV1{
+				Id:      int64(eid),
+				TypeLen: uint16(len(name)),
+				DataLen: uint32(len(data)),
+			},
+			spawning,
+		})
+		s.write([]byte(name))
+		s.write(data)
+	}
+	return s.err
+}
+
+// Reads a snapshot written by Save and returns the World it describes
+func Load(in io.Reader) (w *World, err error) {
+	read := func(v interface{}) {
+		if err == nil {
+			err = binary.Read(in, binary.LittleEndian, v)
+		}
+	}
+	var h snapshotHeader
+	read(&h)
+	if err != nil {
+		return nil, err
+	}
+	if h.Magic != snapshotMagic {
+		return nil, ErrNotSnapshot
+	}
+	switch h.Version {
+	case 1, 2, 3, 4, SnapshotVersion:
+	default:
+		return nil, fmt.Errorf("unsupported snapshot version %d", h.Version)
+	}
+	var state snapshotState
+	if h.Version < 3 {
+		read(&state.snapshotStateV2)
+	} else {
+		read(&state)
+	}
+	walls, doorIds := layer.NewLayer(), layer.NewLayer()
+	defer walls.Discard()
+	defer doorIds.Discard()
+	if err == nil {
+		err = walls.Decode(in)
+	}
+	if err == nil {
+		err = doorIds.Decode(in)
+	}
+	if err != nil {
+		return nil, err
+	}
+	w = NewWorld(0)
+	// Rebuild wall trees and rooms
+	for _, bid := range walls.NonZeroBlocks() {
+		l := game.Location{BlockId: bid}
+		for l.Y = 0; l.Y < game.BLOCK_SIZE; l.Y++ {
+			for l.X = 0; l.X < game.BLOCK_SIZE; l.X++ {
+				if walls.Get(l) == 0 {
+					continue
+				}
+				if w.SetWall(l) == nil {
+					w.Discard()
+					return nil, fmt.Errorf("invalid wall at %v", l)
+				}
+			}
+		}
+	}
+	// Doors
+	var doorCount uint32
+	read(&doorCount)
+	for i := uint32(0); i < doorCount && err == nil; i++ {
+		var dr doorRecord
+		if h.Version < 5 {
+			read(&dr.doorRecordV4)
+		} else {
+			read(&dr)
+		}
+		if dr.State < 0 || DoorState(dr.State) >= NUM_DOOR_STATES {
+			err = fmt.Errorf("invalid state %d of door %d", dr.State, dr.Id)
+		}
+		d := &Door{
+			Id:    DoorId(dr.Id),
+			O:     Orientation(dr.Horz),
+			State: DoorState(dr.State),
+			L: game.Location{
+				BlockId: game.BlockId{X: int(dr.BX), Y: int(dr.BY)},
+				X:       dr.X,
+				Y:       dr.Y,
+			},
+			w: w,
+		}
+		if err == nil && !w.CanPlaceDoor(d.L, d.O) {
+			err = fmt.Errorf("invalid door %d at %v", d.Id, d.L)
+		}
+		if err == nil {
+			w.placeDoor(d, nil)
+		}
+	}
+	if err == nil && !w.DoorIds.Equal(doorIds) {
+		err = errors.New("doors inconsistent with DoorIds layer")
+	}
+	// Zoning
+	var zoningCount uint32
+	if h.Version >= 4 {
+		read(&zoningCount)
+	}
+	for i := uint32(0); i < zoningCount && err == nil; i++ {
+		var zr zoningRecord
+		read(&zr)
+		l := game.Location{
+			BlockId: game.BlockId{X: int(zr.BX), Y: int(zr.BY)},
+			X:       zr.X,
+			Y:       zr.Y,
+		}
+		z := Zoning{
+			Category: ShopCategory(zr.Category),
+			Capacity: int(zr.Capacity),
+			Rent:     int(zr.Rent),
+			Opens:    game.Tick(zr.Opens),
+			Closes:   game.Tick(zr.Closes),
+		}
+		if err == nil && !w.SetZoning(RoomId(w.RoomIds.Get(l)), z) {
+			err = fmt.Errorf("zoning outside any room at %v", l)
+		}
+	}
+	if err != nil {
+		w.Discard()
+		return nil, err
+	}
+	w.ticks = game.Tick(state.Ticks)
+	w.nextDoorId = DoorId(state.NextDoorId)
+	w.nextEntityId = EntityId(state.NextEntityId)
+	if h.Version >= 3 {
+		w.seed = state.Seed
+		w.deterministic = state.Deterministic
+	}
+	// Entities
+	var entityCount uint32
+	read(&entityCount)
+	for i := uint32(0); i < entityCount && err == nil; i++ {
+		var er entityRecord
+		if h.Version == 1 {
+			read(&er.entityRecordV1)
+			er.Spawning = true
+		} else {
+			read(&er)
+		}
+		name := make([]byte, er.TypeLen)
+		data := make([]byte, er.DataLen)
+		read(name)
+		read(data)
+		if err != nil {
+			break
+		}
+		factory := entityRegistry[string(name)]
+		if factory == nil {
+			err = fmt.Errorf("entity type %q not registered", name)
+			break
+		}
+		e := factory()
+		if err = e.Unmarshal(data); err != nil {
+			break
+		}
+		id := EntityId(er.Id)
+		sc := w.entityCursor(e.Location())
+		if sc.Get(0) != ENTITYID_INVALID || w.Entities[id] != nil {
+			err = fmt.Errorf("entity %d overlaps another entity", id)
+			break
+		}
+		if r, ok := e.(EntityRestorer); ok && !er.Spawning {
+			w.restore(r, id, sc)
+		} else {
+			w.spawn(e, id, sc)
+		}
+	}
+	if err != nil {
+		w.Discard()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Restores e with EntityId id, calling e's Restored event immediately
+func (w *World) restore(e EntityRestorer, id EntityId, sc layer.StackCursor) {
+	sc.Set(0, game.TileId(id))
+	w.Entities[id] = e
+	w.touchEntity(id)
+	taTmp := w.allocateAA(w.ticks + 1)
+	taTmp.owner = id
+	e.Restored(taTmp, id, w, &sc)
+	taTmp.Close()
+	w.process(taTmp, false)
+	ReleaseAA(taTmp)
+}
@@ -0,0 +1,130 @@
+package world
+
+import (
+	"bytes"
+	"encoding/binary"
+	"jds/game"
+	"jds/game/layer"
+	"testing"
+)
+
+// Minimal Entity that can be saved
+type savedEntity struct {
+	l       game.Location
+	spawned bool
+}
+
+func (e *savedEntity) Location() game.Location { return e.l }
+func (e *savedEntity) Spawned(ta *ActionAccumulator, id EntityId, w *World, sc *layer.StackCursor) {
+	e.spawned = true
+}
+func (e *savedEntity) Touched(otherEid EntityId, d game.Direction) {}
+func (e *savedEntity) HitWall(d game.Direction)                    {}
+func (e *savedEntity) Color() game.Color                           { return game.Color{} }
+func (e *savedEntity) EntityType() string                          { return "world.savedEntity" }
+
+func (e *savedEntity) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	err := binary.Write(&buf, binary.LittleEndian, [4]int64{
+		int64(e.l.BlockId.X), int64(e.l.BlockId.Y), int64(e.l.X), int64(e.l.Y),
+	})
+	return buf.Bytes(), err
+}
+
+func (e *savedEntity) Unmarshal(data []byte) error {
+	var v [4]int64
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &v); err != nil {
+		return err
+	}
+	e.l = game.Location{
+		BlockId: game.BlockId{X: int(v[0]), Y: int(v[1])},
+		X:       int8(v[2]),
+		Y:       int8(v[3]),
+	}
+	return nil
+}
+
+func init() {
+	RegisterEntity("world.savedEntity", func() EntityUnmarshaler {
+		return new(savedEntity)
+	})
+}
+
+func TestSaveLoad(t *testing.T) {
+	w := NewWorld(0)
+	l := game.Location{}.JustOffset(-40, -40)
+	w.DrawBox(l, l.JustOffset(100, 50))
+	w.DrawBox(l, l.JustOffset(50, 50))
+	w.DrawBox(l.JustOffset(60, 10), l.JustOffset(70, 20))
+	if w.NewDoor(l.JustOffset(49, 10), VERT, nil) == nil {
+		t.Fatal("couldn't place door")
+	}
+	oneway := w.NewDoor(l.JustOffset(62, 19), HORZ, nil)
+	if oneway == nil {
+		t.Fatal("couldn't place door")
+	}
+	w.SetDoorState(oneway.Id, DOOR_ONEWAY)
+	shop := Zoning{Category: SHOP_ELECTRONICS, Capacity: 5, Rent: 50, Opens: 600, Closes: 1200}
+	w.SetZoning(RoomId(w.RoomIds.Get(l.JustOffset(65, 15))), shop)
+	eid := w.Spawn(&savedEntity{l: l.JustOffset(5, 5)})
+	for i := 0; i < 10; i++ {
+		w.Think()
+	}
+	var buf bytes.Buffer
+	if err := w.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	saved := append([]byte(nil), buf.Bytes()...)
+	w2, err := Load(bytes.NewReader(saved))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := w2.Fsck(); len(errs) != 0 {
+		t.Fatal(FsckErrors(errs))
+	}
+	if !w.Walls.Equal(w2.Walls) || !w.DoorIds.Equal(w2.DoorIds) {
+		t.Error("layers differ after load")
+	}
+	if len(w.Rooms) != len(w2.Rooms) || len(w.Doors) != len(w2.Doors) {
+		t.Error("rooms or doors differ after load")
+	}
+	if z, ok := w2.Zoning(RoomId(w2.RoomIds.Get(l.JustOffset(65, 15)))); !ok || z != shop {
+		t.Error("zoning not restored:", z, ok)
+	}
+	if d := w2.Doors[oneway.Id]; d == nil || d.State != DOOR_ONEWAY {
+		t.Error("door state not restored")
+	}
+	if len(w2.zoning) != 1 {
+		t.Error("wrong number of zoned rooms after load")
+	}
+	if w.Now() != w2.Now() {
+		t.Error("tick differs after load")
+	}
+	e, ok := w2.Entities[eid].(*savedEntity)
+	if !ok || e.l != l.JustOffset(5, 5) || EntityId(w2.EntityIds.Get(e.l)) != eid {
+		t.Fatal("entity not restored")
+	}
+	// Saving the loaded world gives the same snapshot
+	buf.Reset()
+	if err := w2.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, buf.Bytes()) {
+		t.Error("re-saved snapshot differs")
+	}
+	w2.Think()
+	if !e.spawned {
+		t.Error("restored entity not spawned")
+	}
+}
+
+func TestLoadBadSnapshot(t *testing.T) {
+	if _, err := Load(bytes.NewReader([]byte("garbage garbage garbage"))); err != ErrNotSnapshot {
+		t.Error("expected ErrNotSnapshot, got", err)
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, snapshotHeader{snapshotMagic, SnapshotVersion + 1})
+	if _, err := Load(&buf); err == nil {
+		t.Error("loaded unsupported snapshot version")
+	}
+}
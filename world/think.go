@@ -9,8 +9,17 @@ func (w *World) Think() {
 	start := time.Now()
 	// increment time
 	w.ticks++
+	// Recount occupancy now, rather than by the first query during Think,
+	// when Entities are moving
+	w.occMutex.Lock()
+	w.refreshOccupancy()
+	w.occMutex.Unlock()
+	// Spawned events for new entities happen this tick
+	for eid := range w.spawning {
+		delete(w.spawning, eid)
+	}
 	// Buffer ScheduledActions for w.ticks from actionSchedule
-	taTmp := AllocateAA(w.ticks)
+	taTmp := w.allocateAA(w.ticks)
 	for w.actionSchedule.Len() > 0 {
 		if w.actionSchedule.PeekTick() > w.ticks {
 			break
@@ -27,7 +36,15 @@ func (w *World) Think() {
 	wuExe := w.workUnits[WU_EXECUTE]
 	wuLen := len(wuExe)
 	if wuLen == 0 {
-		// No work units -- nothing to do
+		// No work units -- nothing to do but jobs
+		w.runJobs()
+		w.ThinkStats.Elapsed += time.Since(start)
+		return
+	}
+	if w.deterministic {
+		w.endTick(w.executePhased(wuExe))
+		w.runJobs()
+		w.ThinkStats.Elapsed += time.Since(start)
 		return
 	}
 	// Worker closure
@@ -39,7 +56,7 @@ func (w *World) Think() {
 				panic("tried to execute completed workUnit")
 			}
 			for _, action := range wuExe[i].Actions {
-				action(aa)
+				w.run(action, aa)
 			}
 			wuExe[i].done = true
 		}
@@ -148,7 +165,7 @@ func (w *World) Think() {
 				wuExe[wuRunEnd+1].locked = true
 			}
 			// Allocate an AA for the worker
-			aa := AllocateAA(w.ticks + 1)
+			aa := w.allocateAA(w.ticks + 1)
 			workerAAs = append(workerAAs, aa)
 			// Launch worker
 			wgWorkers.Add(1)
@@ -170,7 +187,47 @@ func (w *World) Think() {
 	}
 	// moreWork == false
 	wgWorkers.Wait()
-	// end-of-tick
+	w.endTick(workerAAs)
+	w.runJobs()
+	w.ThinkStats.Elapsed += time.Since(start)
+}
+
+// Executes the workUnits in wuExe in three phases, each with its own
+// ActionAccumulator. During phase p, the workUnits with index i%3 == p are
+// executed in parallel. Like the workers in Think, a workUnit may touch its
+// neighbors, so workUnits 3 apart never touch the same columns and the
+// result does not depend on scheduling.
+//
+// Returns the ActionAccumulators, in the same order as wuExe
+func (w *World) executePhased(wuExe []workUnit) []*ActionAccumulator {
+	aas := make([]*ActionAccumulator, len(wuExe))
+	wg := sync.WaitGroup{}
+	for phase := 0; phase < 3; phase++ {
+		for i := phase; i < len(wuExe); i += 3 {
+			if len(wuExe[i].Actions) == 0 {
+				continue
+			}
+			aas[i] = w.allocateAA(w.ticks + 1)
+			w.ThinkStats.Actions += len(wuExe[i].Actions)
+			w.ThinkStats.Workers++
+			wg.Add(1)
+			go func(wu *workUnit, aa *ActionAccumulator) {
+				for _, action := range wu.Actions {
+					w.run(action, aa)
+				}
+				wu.done = true
+				aa.Close()
+				wg.Done()
+			}(&wuExe[i], aas[i])
+		}
+		wg.Wait()
+	}
+	return aas
+}
+
+// Processes the ActionAccumulators of the workers, in order, and clears
+// WU_EXECUTE
+func (w *World) endTick(workerAAs []*ActionAccumulator) {
 	// All workunits are done, process remaining ActionAccumulators
 	for i := range workerAAs {
 		if workerAAs[i] != nil {
@@ -189,5 +246,4 @@ func (w *World) Think() {
 		v.done = false
 		v.locked = false
 	}
-	w.ThinkStats.Elapsed += time.Since(start)
 }
@@ -5,7 +5,7 @@ import (
 	"jds/game"
 	"jds/game/layer"
 	"jds/runstat"
-	"runtime/debug"
+	"math/rand"
 	"sort"
 	"sync"
 	"time"
@@ -45,7 +45,7 @@ func (l LayerError) String() string {
 
 // A workUnit is a set of Actions to be performed in a single World column
 type workUnit struct {
-	Actions []Action
+	Actions []ScheduledAction
 	X       int  // The X value of the World column of this workUnit
 	locked  bool // true if a worker is currently executing the Actions in the workUnit, or if a worker is executing actions in a neighboring column
 	done    bool // true if a worker is done
@@ -82,12 +82,54 @@ type World struct {
 	ticks             game.Tick
 	ActionCount       int
 	customLayers      map[string]*layer.Layer
+	customData        map[string]interface{}
 	clMutex           sync.Mutex
 	ThinkStats        struct {
 		Actions int
 		Workers int
+		Jobs    int
 		Elapsed time.Duration
-	}
+		// Jobs still waiting after the last tick, see PendingJobs
+		JobQueue int
+	}
+	// Jobs waiting to run, see ActionAccumulator.Submit
+	jobs []job
+	// Incremented each time a room is modified, see RoomGeneration
+	generation uint64
+	roomGen    map[RoomId]uint64
+	// see SetZoning
+	zoning    map[RoomId]Zoning
+	zoningGen uint64 // see ZoningGeneration
+	// see SubscribeRooms
+	roomLog roomLog
+	// see RoomGraph, guarded by clMutex
+	roomGraph *RoomGraph
+	// Pending keyed Actions, see ActionAccumulator.AddKeyed
+	keyed      map[actionKey]*ActionHandle
+	keyedMutex sync.Mutex
+	// Entities in each room as of generation occGen, see Occupancy
+	occupancy map[RoomId]int
+	occGen    uint64
+	occMutex  sync.Mutex
+	// Told of Entity movement if not nil, see SetRecorder
+	recorder *Recorder
+	// see BlockGeneration
+	blockGen map[game.BlockId]uint64
+	wallGen  uint64
+	// Entities whose Spawned event happens next tick
+	spawning map[EntityId]bool
+	// see SetSeed
+	seed          int64
+	deterministic bool
+	// see Hash
+	mods         game.ModMap // blocks modified since the last Hash
+	entityBlocks game.ModMap // blocks with entities at the last Hash
+	blockHash    map[game.BlockId]uint64
+	layersHash   uint64
+	entityHash   map[EntityId]uint64 // see hashEntity
+	entitiesHash uint64              // sum of entityHash
+	entityMods   map[EntityId]bool   // Entities changed since the last Hash
+	entityMutex  sync.Mutex          // guards entityMods
 }
 
 const (
@@ -114,6 +156,87 @@ func (w *World) CustomLayer(name string) (l *layer.Layer) {
 	return
 }
 
+// Like CustomLayer, but for arbitrary data attached to the World by other
+// packages. If there is no data called name, the value returned by create()
+// is stored and returned.
+func (w *World) CustomData(name string, create func() interface{}) (v interface{}) {
+	w.clMutex.Lock()
+	v = w.customData[name]
+	if v == nil {
+		v = create()
+		w.customData[name] = v
+	}
+	w.clMutex.Unlock()
+	return
+}
+
+// Returns a value that changes each time the room with RoomId rid is
+// created, deleted, repainted, remapped, or has a Door added or removed.
+// Zoning changes don't count, see ZoningGeneration.
+// Returns 0 if rid has never been used.
+//
+// Data derived from a room can be cached along with its generation, and
+// discarded when the generation changes.
+func (w *World) RoomGeneration(rid RoomId) uint64 {
+	return w.roomGen[rid]
+}
+
+// Returns a value that changes each time the walls in block bid may have
+// changed, or a Door in it has changed State. Returns 0 if no wall operation
+// has touched bid.
+//
+// Data derived from the walls of a block can be cached along with its
+// generation, and discarded when the generation changes.
+func (w *World) BlockGeneration(bid game.BlockId) uint64 {
+	return w.blockGen[bid]
+}
+
+// Returns a value that changes each time a wall operation touches any block,
+// see BlockGeneration
+func (w *World) WallGeneration() uint64 {
+	return w.wallGen
+}
+
+// Returns the blocks touched by wall operations since WallGeneration
+// returned 'since'
+func (w *World) ChangedBlocks(since uint64) game.ModMap {
+	m := game.NewModMap()
+	if since == w.wallGen {
+		return m
+	}
+	for bid, gen := range w.blockGen {
+		if gen > since {
+			m.AddBlock(bid)
+		}
+	}
+	return m
+}
+
+// Returns a value that changes each time any room changes, see
+// RoomGeneration
+func (w *World) Generation() uint64 {
+	return w.generation
+}
+
+// Marks the blocks in m as modified by a wall operation, see
+// BlockGeneration
+func (w *World) touchBlocks(m game.ModMap) {
+	w.wallGen++
+	for bid := range m {
+		w.blockGen[bid] = w.wallGen
+	}
+}
+
+// Marks room rid as modified, see RoomGeneration
+func (w *World) touchRoom(rid RoomId) {
+	if rid == ROOMID_INVALID {
+		return
+	}
+	w.roomLog.touch(w, rid)
+	w.generation++
+	w.roomGen[rid] = w.generation
+}
+
 // Copies the Actions in 'aa' for next tick into into WU_BUFFER, and the
 // Actions for later ticks into w.actionSchedule
 //
@@ -144,7 +267,7 @@ func (w *World) process(aa *ActionAccumulator, actionsOnly bool) {
 			w.workUnits[WU_BUFFER][i] = workUnit{X: t[0].BlockId.X}
 		}
 		for _, th := range t {
-			w.workUnits[WU_BUFFER][i].Actions = append(w.workUnits[WU_BUFFER][i].Actions, th.Do)
+			w.workUnits[WU_BUFFER][i].Actions = append(w.workUnits[WU_BUFFER][i].Actions, th)
 		}
 	}
 
@@ -174,21 +297,14 @@ func (w *World) process(aa *ActionAccumulator, actionsOnly bool) {
 		}
 		aa.E.Spawns = aa.E.Spawns[:0]
 		for _, eid := range aa.E.Deaths {
-			// TODO this is a hack. at least make a Kill funcion ala Spawn
-			e := w.Entities[eid]
-			if e == nil {
-				// already dead?
-				continue
-			}
-			// Sanity check
-			if EntityId(w.EntityIds.Get(e.Location())) != eid {
-				panic("wrong entity location")
-			}
-			w.EntityIds.Set(e.Location(), 0)
-			delete(w.Entities, eid)
-			//panic("entity deaths not implemented")
+			w.Kill(eid)
 		}
 		aa.E.Deaths = aa.E.Deaths[:0]
+		w.jobs = append(w.jobs, aa.jobs...)
+		for i := range aa.jobs {
+			aa.jobs[i] = job{}
+		}
+		aa.jobs = aa.jobs[:0]
 	}
 }
 
@@ -203,6 +319,13 @@ func NewWorld(strictFlags int) *World {
 		Doors:        make(map[DoorId]*Door),
 		Entities:     make(map[EntityId]Entity),
 		customLayers: make(map[string]*layer.Layer),
+		customData:   make(map[string]interface{}),
+		roomGen:      make(map[RoomId]uint64),
+		zoning:       make(map[RoomId]Zoning),
+		keyed:        make(map[actionKey]*ActionHandle),
+		occupancy:    make(map[RoomId]int),
+		blockGen:     make(map[game.BlockId]uint64),
+		spawning:     make(map[EntityId]bool),
 		strict:       strictFlags,
 		DoorIds:      layer.NewLayer(),
 		EntityIds:    layer.NewLayer(),
@@ -210,6 +333,12 @@ func NewWorld(strictFlags int) *World {
 		RoomIds:      layer.NewLayer(),
 		Walls:        layer.NewLayer(),
 		sc:           layer.NewStackCursor(game.Location{}),
+		seed:         rand.Int63(),
+		mods:         game.NewModMap(),
+		entityBlocks: game.NewModMap(),
+		blockHash:    make(map[game.BlockId]uint64),
+		entityHash:   make(map[EntityId]uint64),
+		entityMods:   make(map[EntityId]bool),
 	}
 	if w.sc.Add(w.Walls) != wallIndex ||
 		w.sc.Add(w.ForcedFlags) != flagIndex ||
@@ -355,220 +484,10 @@ func computeLeftAndRightMost(a, b, stop *WallTreeNode) (left []game.Location, wi
 	return
 }
 
-func (w *World) Fsck() {
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println(r)
-			panic(r)
-			fmt.Println("continuing after fsck errors")
-		}
-	}()
-	w.fsck()
-}
-
-func (w *World) fsck() {
-	defer runstat.Record(time.Now(), "FsckWallTree")
-	//n := w.WallNodes[l]
-	//if n == nil {
-	//		panic("location is not in a wall tree")
-	//	}
-	seenRids := make(map[RoomId]game.Location)
-	// Check all nodes
-	for l, nn := range w.WallNodes {
-		// Check node location
-		if nn.L != l {
-			panic("location is wrong")
-		}
-		if w.RoomIds.Get(nn.L) != 0 {
-			errorLayer := layer.NewLayer()
-			errorLayer.Set(nn.L, 1)
-			panic(LayerError{
-				Layer:   errorLayer,
-				Message: "wall with non-zero roomid under it",
-			})
-		}
-		// All adjacent wall tiles should be in the same tree
-		for _, nl := range nn.L.Neighbors() {
-			if nnn := w.WallNodes[nl]; nnn != nil {
-				if nnn.R != nn.R {
-					panic("adjacent tile from different tree")
-				}
-			}
-		}
-		// Neighbor pointers
-		if nn.P != nil && nn.P.N[3-nn.D] != nn {
-			fmt.Println("consistency error", nn.L.String(), nn.P.L.String())
-			panic("neighbor pointer consistency error")
-		}
-		// Check node's Room pointers
-		for rid, d := range nn.RoomIds {
-			if rid == ROOMID_INVALID {
-				panic("node contains invalid room id")
-			}
-			r := w.Rooms[rid]
-			if r == nil {
-				w.dumpRooms()
-				fmt.Println("rid=", rid)
-				panic("node contains reference to deleted room")
-			}
-			// nn is the LNP for Room r
-			if nn.L != r.LNPL() {
-				panic("LNPL inconsistent")
-			}
-			if nn != r.LNP() {
-				panic("LNP inconsistent")
-			}
-			if d != r.LNPD {
-				panic("LNPD inconsistent")
-			}
-			if _, ok := seenRids[rid]; ok {
-				fmt.Println("rid", rid, nn.L, seenRids[rid])
-				panic("more than one LNP for rid")
-			}
-			seenRids[rid] = nn.L
-		}
-		// Check node depth
-		depth := 0
-		for nnn := nn; nnn != nnn.R; nnn = nnn.P {
-			if nnn.Depth != nnn.P.Depth+1 {
-				fmt.Println(nnn.Depth)
-				panic("node depth incorrect1")
-			}
-			depth++
-		}
-		if depth != nn.Depth {
-			fmt.Println("want", nn.Depth, "got", depth)
-			panic("node depth incorrect")
-		}
-	}
-	// Check all rooms
-	// Map range traversal order is random, so sort keys first
-	rids := make([]int, 0)
-	for rid, r := range w.Rooms {
-		if r.id != rid {
-			panic("stored rid doesn't match key")
-		}
-		rids = append(rids, int(rid))
-	}
-	sort.Ints(rids)
-	// check room structs
-	for _, rid := range rids {
-		r := w.Rooms[RoomId(rid)]
-		n1 := r.LinkingNode()
-		n2 := w.WallNodes[n1.L]
-		if n2 == nil || n1.R != n2.R {
-			fmt.Println("rid", rid)
-			fmt.Println(n1, n2)
-			fmt.Println(len(w.WallNodes), n1.L)
-			layer := layer.NewLayer()
-			layer.Set(n1.L, 1)
-			panic(LayerError{
-				Message: "linking node is from different tree",
-				Layer:   layer,
-			})
-		}
-		if d, ok := r.LNP().RoomIds[r.id]; ok {
-			if d != r.LNPD {
-				panic("r.LNP's direction inconsistent with r's")
-			}
-		} else {
-			panic("room points to node, but node doesn't point to room")
-		}
-	}
-	roomTiles := make(map[game.Location]bool)
-	if w.strict&STRICT_PARANOID != 0 {
-		for _, loc := range w.RoomIds.DeepSearchNonZero() {
-			roomTiles[loc] = true
-		}
-	}
-	for _, rid := range rids {
-		rid := RoomId(rid)
-		r := w.Rooms[RoomId(rid)]
-		interiorArea := 0
-		r.paint(func(rm *game.RowMask, ridRow []game.TileId) bool {
-			// sanity check
-			if rm.Width() != len(ridRow) {
-				panic("interior error")
-			}
-			for i := 0; i < rm.Width(); i++ {
-				inside, skip := rm.Mask(i)
-				if inside {
-					interiorArea++
-					if RoomId(ridRow[i]) != rid {
-						fmt.Println("got", ridRow[i], "wanted", rid, "actual", w.RoomIds.Get(rm.Left))
-						panic("interior error")
-					}
-					rm.Left, _, _ = rm.Left.Right()
-				} else {
-					i += skip - 1
-					rm.Left, _, _ = rm.Left.Offset(skip, 0)
-				}
-			}
-			return true
-		})
-		if interiorArea != r.Area {
-			roomTiles := w.RoomIds.DeepSearch(game.TileId(rid))
-			trueArea := len(roomTiles)
-			panic(LayerError{
-				Message: fmt.Sprintln("rid", rid, "interior area", interiorArea, "actual area", trueArea, "recorded area", r.Area),
-				Layer:   layer.NewLayerFromSlice(roomTiles, game.TileId(rid)),
-			})
-		}
-		nonempty, il := r.IsNonEmpty()
-		if !nonempty && r.Area != 0 {
-			panic("room is empty, but has positive area")
-		}
-		if w.strict&STRICT_PARANOID != 0 {
-			// Exhaustively verify room area is correct and rooms are connected. very slow.
-			connectedArea := 0
-			if nonempty {
-				for _, l := range w.RoomIds.Flood(il) {
-					roomTiles[l] = false
-					connectedArea++
-					if connectedArea > 10000 {
-						panic("room area limit hit")
-					}
-					if RoomId(w.RoomIds.Get(l)) != rid {
-						panic("room id's not consistent")
-					}
-				}
-			}
-			if connectedArea != r.Area {
-				roomTiles := w.RoomIds.DeepSearch(game.TileId(rid))
-				trueArea := len(roomTiles)
-				panic(LayerError{
-					Message: fmt.Sprintln("rid", rid, "computed area", connectedArea, "actual area", trueArea, "recorded area", r.Area),
-					Layer:   layer.NewLayerFromSlice(roomTiles, game.TileId(rid)),
-				})
-			}
-		}
-		// Check Door references
-		for _, did := range r.DoorIds {
-			d := w.Doors[did]
-			for _, rid := range d.R {
-				if rid == r.id {
-					goto okay
-				}
-			}
-			panic("Missing Door->Room reference. Room contains stale DoorIds entry?")
-		okay:
-		}
-	}
-	// verify that every room tile was visited. this is vacuous if STRICT_PARANOID is not set (roomTiles uninitialized)
-	for k, v := range roomTiles {
-		if v == true {
-			panic(fmt.Sprintln(k, "belongs to non-existent room", w.RoomIds.Get(k)))
-		}
-	}
-	// Check all doors
-	for _, v := range w.Doors {
-		v.fsck()
-	}
-}
-
 // Sets the RoomId of all interior tiles of r to 0, and updates all connected
 // doors
 func (r *Room) clear(m game.ModMap) {
+	r.w.touchRoom(r.id)
 	r.paint(func(rm *game.RowMask, ridRow []game.TileId) bool {
 		m.AddRowMask(rm)
 		r.w.RoomIds.SetRowMask(rm, 0, m)
@@ -581,7 +500,7 @@ func (r *Room) clear(m game.ModMap) {
 	return
 }
 
-func (w *World) changeRoomId(start game.Location, old, new RoomId) {
+func (w *World) changeRoomId(start game.Location, old, new RoomId, m game.ModMap) {
 	if new == 0 {
 		panic("can't assign roomId 0")
 	}
@@ -599,8 +518,11 @@ func (w *World) changeRoomId(start game.Location, old, new RoomId) {
 			Message: fmt.Sprintf("New RoomID already in use. Old:%d New:%d", old, new),
 		})
 	}
+	w.touchRoom(old)
+	w.touchRoom(new)
 	r := w.Rooms[old]
 	r.paint(func(rm *game.RowMask, rid []game.TileId) bool {
+		m.AddRowMask(rm)
 		for i := 0; i < rm.Width(); i++ {
 			l, _ := rm.Mask(i)
 			if l {
@@ -612,6 +534,14 @@ func (w *World) changeRoomId(start game.Location, old, new RoomId) {
 	})
 	r.id = new
 	w.Rooms[r.id] = r
+	w.roomLog.remapped(old, new)
+	if z, ok := w.zoning[old]; ok {
+		w.zoning[new] = z
+		w.zoningGen++
+	} else {
+		delete(w.zoning, new)
+	}
+	delete(w.zoning, old)
 	// update linking node's direction map
 	r.LNP().RoomIds[r.id] = r.LNP().RoomIds[old]
 	// Update doors
@@ -635,11 +565,12 @@ func (w *World) DeleteFromWallTree(loc game.Location) (m game.ModMap) {
 	}
 	w.deleteFromWallTree(loc, m)
 	w.DeleteOps++
-	w.runRoomIdChanges()
+	w.runRoomIdChanges(m)
 	w.updateForcedFlags(loc)
-	if w.strict&STRICT_FSCK_EVERY_OP != 0 {
-		w.Fsck()
-	}
+	w.touchBlocks(m)
+	w.mods.Merge(m)
+	w.strictFsck()
+	w.emitRoomEvents()
 	return m
 }
 
@@ -652,6 +583,7 @@ func (w *World) deleteFromWallTree(locationToDelete game.Location, m game.ModMap
 	m.AddLocation(locationToDelete)
 	// EXP clear neighboring rooms
 	nbd_rooms := make(map[RoomId]*WallTreeNode)
+	var merging []mergingRoom
 	largestRoom := RoomId(ROOMID_INVALID)
 	largestArea := 0
 	for _, l := range locationToDelete.Neighborhood() {
@@ -662,6 +594,7 @@ func (w *World) deleteFromWallTree(locationToDelete game.Location, m game.ModMap
 				largestArea = neighborRoom.Area
 			}
 			nbd_rooms[rid] = neighborRoom.LNP()
+			merging = append(merging, mergingRoom{L: l, Area: neighborRoom.Area, Id: rid})
 			neighborRoom.clear(m)
 		}
 	}
@@ -684,6 +617,10 @@ func (w *World) deleteFromWallTree(locationToDelete game.Location, m game.ModMap
 			room.init(m)
 		}
 	}
+	w.mergeZoning(merging)
+	for _, mr := range merging {
+		w.roomLog.moved(mr.Id, RoomId(w.RoomIds.Get(mr.L)))
+	}
 	// After deleting a wall, some rooms may be merged.
 	// It is desirable to have the RoomId of the merged room be that of the
 	// largest (by area) constituent of the merge. If the RoomId at the deleted
@@ -746,12 +683,13 @@ func (w *World) AddToWallTree(l game.Location) (m game.ModMap) {
 	m.AddLocation(l)
 	w.addToWallTree(l, m)
 	w.AddOps++
-	w.runRoomIdChanges()
+	w.runRoomIdChanges(m)
 	w.ForcedFlags.Set(l, game.TileId(0xff)) // walls have all forced flags set, so pathfinding jumps in every direction will stop at walls
 	w.updateForcedFlags(l)
-	if w.strict&STRICT_FSCK_EVERY_OP != 0 {
-		w.Fsck()
-	}
+	w.touchBlocks(m)
+	w.mods.Merge(m)
+	w.strictFsck()
+	w.emitRoomEvents()
 	return m
 }
 
@@ -771,7 +709,7 @@ func (w *World) updateForcedFlags(l game.Location) {
 	}
 }
 
-func (w *World) runRoomIdChanges() {
+func (w *World) runRoomIdChanges(m game.ModMap) {
 	for _, r := range w.roomIdRemapStack {
 		room := w.Rooms[r.Old]
 		if room == nil {
@@ -786,7 +724,7 @@ func (w *World) runRoomIdChanges() {
 		if !nonempty {
 			panic("empty room not cleaned up")
 		}
-		w.changeRoomId(il, r.Old, r.New)
+		w.changeRoomId(il, r.Old, r.New, m)
 		// Update door references
 		for _, did := range room.DoorIds {
 			door := w.Doors[did]
@@ -794,6 +732,7 @@ func (w *World) runRoomIdChanges() {
 		}
 	}
 	w.roomIdRemapStack = nil
+	w.pruneZoning()
 }
 
 func (w *World) addToWallTree(locationToAdd game.Location, m game.ModMap) {
@@ -804,6 +743,7 @@ func (w *World) addToWallTree(locationToAdd game.Location, m game.ModMap) {
 	}
 	// If this location is in a room, decrease its area
 	if rid := RoomId(w.sc.Get(roomIndex)); rid != 0 {
+		w.touchRoom(rid)
 		w.Rooms[rid].Area--
 		w.RoomIds.Set(locationToAdd, 0)
 	}
@@ -977,6 +917,7 @@ func (w *World) deleteWallTree(n *WallTreeNode, v game.TileId) (children []game.
 			if nn := w.WallNodes[nl]; nn != nil {
 				for rid, ld := range nn.RoomIds {
 					if game.Direction(d) == ld {
+						w.touchRoom(rid)
 						delete(nn.RoomIds, rid)
 						delete(w.Rooms, rid)
 						// TODO trigger notifications of potential RoomId change (rid->?)
@@ -987,6 +928,7 @@ func (w *World) deleteWallTree(n *WallTreeNode, v game.TileId) (children []game.
 		}
 		// delete all rooms for which n is the linking node parent
 		for rid := range n.RoomIds {
+			w.touchRoom(rid)
 			delete(w.Rooms, rid)
 		}
 		children = append(children, n.L)
@@ -1040,6 +982,7 @@ func (w *World) newRoom(lnp *WallTreeNode, lnpd game.Direction, m game.ModMap) *
 		lnp.RoomIds = make(map[RoomId]game.Direction)
 	}
 	lnp.RoomIds[r.id] = lnpd
+	w.touchRoom(r.id)
 	w.Rooms[r.id] = r
 	r.init(m)
 	return r
@@ -1063,28 +1006,6 @@ func (w *World) dumpRooms() {
 	}
 }
 
-func (w *World) checkRootContinuty(n *WallTreeNode) {
-	fail := false
-	for _, nl := range n.L.Neighbors() {
-		if nn := w.WallNodes[nl]; nn != nil {
-			if nn.R != n.R {
-				fmt.Println(nn.L, n.L, "are different")
-				fail = true
-			}
-		}
-	}
-	if fail {
-		debug.PrintStack()
-		fmt.Printf("%s %p\n", n.L, n.R)
-		for _, nl := range n.L.Neighbors() {
-			if nn := w.WallNodes[nl]; nn != nil {
-				fmt.Printf("%s %p\n", nn.L, nn.R)
-			}
-		}
-		fmt.Println("root ptr not continuous")
-	}
-}
-
 // Computes the y (vertical) component of a vector tangent to the curve
 // bounding a room, returning the result in tangentLayer. A value of 2 or -2
 // indicates vertical tangent. This is used to determine tiles interior and
@@ -1205,19 +1126,37 @@ func ForcedFlags(wallLocal [8]game.TileId) (f uint8) {
 // Spawn Entity 'e' into World 'w'. Returns the EntityId assigned to 'e'
 // and call's e's Spawned event.
 func (w *World) Spawn(e Entity) EntityId {
-	l := e.Location()
-	sc := layer.NewStackCursor(l)
-	sc.Add(w.EntityIds) // Layer index 0
-	sc.Add(w.Walls)     // Layer index 1
+	sc := w.entityCursor(e.Location())
 	if otherEid := EntityId(sc.Get(0)); otherEid != ENTITYID_INVALID {
 		// An entity is already there
 		return ENTITYID_INVALID
 	}
 	id := w.nextEntityId
-	sc.Set(0, game.TileId(id))
 	w.nextEntityId++
+	w.spawn(e, id, sc)
+	return id
+}
+
+// Returns a StackCursor at l with the layers given to Entities
+func (w *World) entityCursor(l game.Location) (sc layer.StackCursor) {
+	sc = layer.NewStackCursor(l)
+	sc.Add(w.EntityIds) // Layer index 0
+	sc.Add(w.Walls)     // Layer index 1
+	return
+}
+
+// Spawns e with EntityId id. sc is a StackCursor at e's location, with
+// layers as described in Spawn
+func (w *World) spawn(e Entity, id EntityId, sc layer.StackCursor) {
+	l := sc.Cursor()
+	sc.Set(0, game.TileId(id))
 	w.Entities[id] = e
-	taTmp := AllocateAA(w.ticks + 1) // TODO we should accept a AA as an argument instead of making one
+	w.spawning[id] = true
+	w.touchEntity(id)
+	w.occupy(l, 1)
+	w.record(MOVE_SPAWN, id, l, game.NONE, ENTITYID_INVALID)
+	taTmp := w.allocateAA(w.ticks + 1) // TODO we should accept a AA as an argument instead of making one
+	taTmp.owner = id
 	taTmp.Add(
 		w.ticks+1,
 		func(ta *ActionAccumulator) {
@@ -1228,7 +1167,57 @@ func (w *World) Spawn(e Entity) EntityId {
 	taTmp.Close()
 	w.process(taTmp, false)
 	ReleaseAA(taTmp)
-	return id
+}
+
+// Removes Entity eid from w, and calls its Despawned event if it is a
+// Despawner. Its pending Actions are cancelled. Returns false if there is no
+// such Entity. Must not be called during Think, use ActionAccumulator.Kill
+// instead.
+func (w *World) Kill(eid EntityId) bool {
+	e := w.Entities[eid]
+	if e == nil {
+		return false
+	}
+	// Sanity check
+	if EntityId(w.EntityIds.Get(e.Location())) != eid {
+		panic("wrong entity location")
+	}
+	w.EntityIds.Set(e.Location(), 0)
+	w.occupy(e.Location(), -1)
+	w.record(MOVE_DEATH, eid, e.Location(), game.NONE, ENTITYID_INVALID)
+	delete(w.Entities, eid)
+	delete(w.spawning, eid)
+	w.touchEntity(eid)
+	if d, ok := e.(Despawner); ok {
+		taTmp := w.allocateAA(w.ticks + 1)
+		d.Despawned(taTmp)
+		taTmp.Close()
+		w.process(taTmp, false)
+		ReleaseAA(taTmp)
+	}
+	return true
+}
+
+// Runs Action a, unless it has been cancelled or rescheduled, or belongs to
+// an Entity that has died
+func (w *World) run(a ScheduledAction, aa *ActionAccumulator) {
+	if !a.live() {
+		return
+	}
+	if a.handle != nil && a.handle.w != nil {
+		// No longer pending
+		w.clearKeyed(a.handle)
+	}
+	if a.Entity != ENTITYID_INVALID {
+		if w.Entities[a.Entity] == nil {
+			return
+		}
+		// The Action may change the Entity's marshaled state
+		w.touchEntity(a.Entity)
+	}
+	aa.owner = a.Entity
+	a.Do(aa)
+	aa.owner = ENTITYID_INVALID
 }
 
 // sc must be a stack cursor at the entity's current location, with w.EntityIds
@@ -1242,20 +1231,35 @@ func (w *World) StepEntity(eid EntityId, e Entity, sc *layer.StackCursor, d game
 	if d == game.NONE {
 		return sc.Cursor(), true
 	}
-	// Collide with wall?
+	// Collide with wall? Wall tiles under a Door can be walked through,
+	// unless its State forbids it.
 	if sc.DirectedGet(1, d) != 0 {
-		e.HitWall(d)
-		return sc.Cursor(), false
+		did := DoorId(w.DoorIds.Get(sc.Cursor().JustStep(d)))
+		if did == 0 || !w.Doors[did].Passable(d) {
+			w.record(MOVE_HIT_WALL, eid, sc.Cursor(), d, ENTITYID_INVALID)
+			e.HitWall(d)
+			return sc.Cursor(), false
+		}
 	}
 	// Collide with other entity?
 	if otherEid := EntityId(sc.DirectedGet(0, d)); otherEid != ENTITYID_INVALID {
+		w.record(MOVE_TOUCH, eid, sc.Cursor(), d, otherEid)
 		e.Touched(otherEid, d)
 		return sc.Cursor(), false
 	}
-	// Move okay
+	// Move okay. Rooms are separated by walls, so the Entity can only
+	// change rooms by stepping onto or off a door.
+	crossing := sc.Get(1) != 0 || sc.DirectedGet(1, d) != 0
+	from := sc.Cursor()
 	sc.Set(0, ENTITYID_INVALID)
 	sc.Step(d)
 	sc.Set(0, game.TileId(eid))
+	w.record(MOVE_STEP, eid, from, d, ENTITYID_INVALID)
+	w.touchEntity(eid)
+	if crossing {
+		w.occupy(from, -1)
+		w.occupy(sc.Cursor(), 1)
+	}
 	return sc.Cursor(), true
 }
 
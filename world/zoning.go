@@ -0,0 +1,227 @@
+// Zoning says what a Room is used for
+//
+// Rooms are created, recolored and deleted as walls change, so zoning is
+// stored in the World by RoomId and follows these rules:
+//
+//   - A RoomId remap moves the zoning to the new RoomId
+//   - When a room is split, each piece keeps the zoning of the original
+//   - When rooms merge, the merged room takes the zoning of its largest
+//     zoned constituent, or is unzoned if none of them were zoned
+//   - The zoning of a deleted room is discarded
+
+package world
+
+import (
+	"fmt"
+	"jds/game"
+	"sort"
+)
+
+// The kind of business in a Room
+type ShopCategory int
+
+const (
+	SHOP_NONE ShopCategory = iota
+	SHOP_CORRIDOR
+	SHOP_FOOD
+	SHOP_CLOTHING
+	SHOP_ELECTRONICS
+	SHOP_GROCERIES
+	NUM_SHOP_CATEGORIES
+)
+
+func (c ShopCategory) String() string {
+	switch c {
+	case SHOP_NONE:
+		return "none"
+	case SHOP_CORRIDOR:
+		return "corridor"
+	case SHOP_FOOD:
+		return "food"
+	case SHOP_CLOTHING:
+		return "clothing"
+	case SHOP_ELECTRONICS:
+		return "electronics"
+	case SHOP_GROCERIES:
+		return "groceries"
+	}
+	return fmt.Sprintf("ShopCategory(%d)", int(c))
+}
+
+// Ticks in a day, for opening hours
+const DAY_TICKS = 24 * 60
+
+type Zoning struct {
+	Category ShopCategory
+	// Maximum number of patrons inside at once, 0 for no limit
+	Capacity int
+	// Paid by the tenant each day
+	Rent int
+	// Opening hours, in ticks since the start of the day. The room is open
+	// from Opens until Closes, which may be on the next day. If Opens ==
+	// Closes the room never closes.
+	Opens, Closes game.Tick
+}
+
+// Returns true if a room zoned z is open at tick t
+func (z Zoning) OpenAt(t game.Tick) bool {
+	if z.Opens == z.Closes {
+		return true
+	}
+	t %= DAY_TICKS
+	if z.Opens < z.Closes {
+		return t >= z.Opens && t < z.Closes
+	}
+	// Open overnight
+	return t >= z.Opens || t < z.Closes
+}
+
+// Sets the zoning of room rid. Returns false if there is no such room. Must
+// not be called during Think.
+func (w *World) SetZoning(rid RoomId, z Zoning) bool {
+	if w.Rooms[rid] == nil {
+		return false
+	}
+	w.zoning[rid] = z
+	w.zoningGen++
+	return true
+}
+
+// Removes the zoning of room rid. Must not be called during Think.
+func (w *World) ClearZoning(rid RoomId) {
+	if _, ok := w.zoning[rid]; ok {
+		delete(w.zoning, rid)
+		w.zoningGen++
+	}
+}
+
+// Returns a value that changes each time the zoning of any room changes,
+// whether by SetZoning or ClearZoning, or by a wall operation moving zoning
+// between rooms. Zoning doesn't change routes, so it has its own generation
+// rather than being part of RoomGeneration.
+func (w *World) ZoningGeneration() uint64 {
+	return w.zoningGen
+}
+
+// Returns the zoning of room rid, or false if it is not zoned
+func (w *World) Zoning(rid RoomId) (z Zoning, ok bool) {
+	if w.Rooms[rid] == nil {
+		return
+	}
+	z, ok = w.zoning[rid]
+	return
+}
+
+// Returns the zoning of r, or false if it is not zoned
+func (r *Room) Zoning() (Zoning, bool) {
+	return r.w.Zoning(r.id)
+}
+
+// Returns the RoomIds of the rooms zoned with category c, in increasing
+// order
+func (w *World) ZonedRooms(c ShopCategory) (rids []RoomId) {
+	for rid, z := range w.zoning {
+		if z.Category == c && w.Rooms[rid] != nil {
+			rids = append(rids, rid)
+		}
+	}
+	sort.Slice(rids, func(i, j int) bool { return rids[i] < rids[j] })
+	return
+}
+
+// Called by Room.init after r has been painted over the tiles of the rooms
+// in 'replaced', which maps RoomId to number of tiles taken. An unzoned r
+// inherits the zoning of the room it took the most tiles from, as r is
+// either a piece of that room or has replaced it.
+//
+// The replaced rooms may already be deleted, so their zoning is kept until
+// the end of the operation, see pruneZoning.
+func (w *World) inheritZoning(r *Room, replaced map[RoomId]int) {
+	if _, ok := w.zoning[r.id]; ok {
+		return
+	}
+	var from RoomId
+	most := 0
+	for rid, n := range replaced {
+		if _, ok := w.zoning[rid]; !ok || rid == r.id {
+			continue
+		}
+		if n > most || (n == most && rid < from) {
+			from, most = rid, n
+		}
+	}
+	if from != ROOMID_INVALID {
+		w.zoning[r.id] = w.zoning[from]
+		w.zoningGen++
+	}
+}
+
+// A room next to a deleted wall, which will be merged with its neighbors
+type mergingRoom struct {
+	L    game.Location // a tile of the room next to the wall
+	Area int
+	Id   RoomId
+}
+
+// Called by deleteFromWallTree once the rooms next to the deleted wall have
+// been rebuilt. Each room that now contains one of the 'merged' rooms gets
+// the zoning of the largest zoned room it contains.
+func (w *World) mergeZoning(merged []mergingRoom) {
+	type best struct {
+		z    Zoning
+		area int
+		ok   bool
+	}
+	zonings := make(map[RoomId]best)
+	for _, mr := range merged {
+		rid := RoomId(w.RoomIds.Get(mr.L))
+		if rid == ROOMID_INVALID {
+			continue
+		}
+		b := zonings[rid]
+		if z, ok := w.zoning[mr.Id]; ok && (!b.ok || mr.Area > b.area) {
+			b = best{z, mr.Area, true}
+		}
+		zonings[rid] = b
+	}
+	for rid, b := range zonings {
+		if b.ok {
+			w.zoning[rid] = b.z
+		} else {
+			delete(w.zoning, rid)
+		}
+		w.zoningGen++
+	}
+}
+
+// Discards the zoning of deleted rooms. Called at the end of each wall
+// operation, once all RoomId remaps are done.
+func (w *World) pruneZoning() {
+	for rid := range w.zoning {
+		if w.Rooms[rid] == nil {
+			delete(w.zoning, rid)
+			w.zoningGen++
+		}
+	}
+}
+
+// Returns the first tile of r in row major order. Unlike the tile returned
+// by IsNonEmpty, this doesn't depend on how r was built.
+func (r *Room) firstTile() (first game.Location, ok bool) {
+	r.Interior(func(rm *game.RowMask) bool {
+		for i := 0; i < rm.Width(); {
+			m, n := rm.Mask(i)
+			if !m {
+				i += n
+				continue
+			}
+			l := rm.Left.JustOffset(i, 0)
+			if x, y := first.Distance(l); !ok || y < 0 || (y == 0 && x < 0) {
+				first, ok = l, true
+			}
+			break
+		}
+		return true
+	})
+	return
+}
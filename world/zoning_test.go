@@ -0,0 +1,92 @@
+package world
+
+import (
+	"jds/game"
+	"testing"
+)
+
+// Returns the zoning of the room containing l
+func zoningAt(w *World, l game.Location) (Zoning, bool) {
+	return w.Zoning(RoomId(w.RoomIds.Get(l)))
+}
+
+func TestZoning(t *testing.T) {
+	w := NewWorld(STRICT_ALL)
+	l := game.Location{}
+	left, right := l.JustOffset(5, 10), l.JustOffset(35, 10)
+	w.DrawBox(l, l.JustOffset(40, 20))
+	if w.SetZoning(RoomId(w.RoomIds.Get(l)), Zoning{}) {
+		t.Error("zoned a wall")
+	}
+	food := Zoning{Category: SHOP_FOOD, Capacity: 10, Rent: 100, Opens: 480, Closes: 1320}
+	rid := RoomId(w.RoomIds.Get(left))
+	gen, zgen := w.RoomGeneration(rid), w.ZoningGeneration()
+	if !w.SetZoning(rid, food) {
+		t.Fatal("couldn't zone room")
+	}
+	// Zoning isn't a change to the room's shape, so cached routes survive
+	if w.RoomGeneration(rid) != gen || w.ZoningGeneration() == zgen {
+		t.Error("zoning changed the wrong generation")
+	}
+	// Both pieces of a split room keep its zoning
+	w.DrawLine(l.JustOffset(25, 0), l.JustOffset(25, 20))
+	if w.RoomIds.Get(left) == w.RoomIds.Get(right) {
+		t.Fatal("room not split")
+	}
+	for _, l := range []game.Location{left, right} {
+		if z, ok := zoningAt(w, l); !ok || z != food {
+			t.Fatal("split room lost zoning:", z, ok)
+		}
+	}
+	if rids := w.ZonedRooms(SHOP_FOOD); len(rids) != 2 {
+		t.Error("wrong zoned rooms:", rids)
+	}
+	// A merged room takes the zoning of the larger room
+	clothing := Zoning{Category: SHOP_CLOTHING}
+	w.SetZoning(RoomId(w.RoomIds.Get(right)), clothing)
+	w.DeleteFromWallTree(l.JustOffset(25, 10))
+	if w.RoomIds.Get(left) != w.RoomIds.Get(right) {
+		t.Fatal("rooms not merged")
+	}
+	if z, _ := zoningAt(w, right); z != food {
+		t.Error("merged room has wrong zoning:", z)
+	}
+	// Unless it isn't zoned
+	w.SetWall(l.JustOffset(25, 10))
+	w.ClearZoning(RoomId(w.RoomIds.Get(left)))
+	w.SetZoning(RoomId(w.RoomIds.Get(right)), clothing)
+	w.DeleteFromWallTree(l.JustOffset(25, 10))
+	if z, _ := zoningAt(w, left); z != clothing {
+		t.Error("merged room has wrong zoning:", z)
+	}
+	if rids := w.ZonedRooms(SHOP_FOOD); len(rids) != 0 {
+		t.Error("zoning of merged rooms not discarded:", rids)
+	}
+	// Deleted rooms lose their zoning
+	w.DeleteFromWallTree(l.JustOffset(10, 0))
+	if _, ok := zoningAt(w, left); ok || len(w.zoning) != 0 {
+		t.Error("deleted room still zoned")
+	}
+}
+
+func TestOpenAt(t *testing.T) {
+	day := Zoning{Opens: 480, Closes: 1320}
+	night := Zoning{Opens: 1320, Closes: 480}
+	for _, c := range []struct {
+		t          game.Tick
+		day, night bool
+	}{
+		{0, false, true},
+		{480, true, false},
+		{1319, true, false},
+		{1320, false, true},
+		{DAY_TICKS + 600, true, false},
+	} {
+		if day.OpenAt(c.t) != c.day || night.OpenAt(c.t) != c.night {
+			t.Error("wrong opening hours at", c.t)
+		}
+	}
+	if !(Zoning{}).OpenAt(100) {
+		t.Error("always open room closed")
+	}
+}